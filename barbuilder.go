@@ -0,0 +1,349 @@
+package ib
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// BAR BUILDER
+////////////////////////////////////////////////////////////////////////////////
+
+type BarKind int64
+
+const (
+	TimeBars   BarKind = iota // a bar every Interval
+	TickBars                  // a bar every Threshold trades
+	VolumeBars                // a bar once Threshold shares have traded
+	DollarBars                // a bar once Threshold of price*size has traded
+)
+
+// Bar is a RealTimeBar built from trades. Corrected bars repeat an earlier
+// time bar that a late trade has changed.
+type Bar struct {
+	RealTimeBar
+	Start     time.Time
+	End       time.Time
+	Corrected bool
+}
+
+// BarBuilder aggregates trades into bars per market data request. Trades are
+// taken from RT volume ticks when the request has them and otherwise from
+// last price ticks, which carry the trade size, and from last size ticks
+// alone. Time bars are aligned to the session open
+// when Sessions is set and to midnight in Location otherwise, and trades that
+// arrive up to Grace, in trade time, after their time bar ended produce a
+// corrected bar.
+type BarBuilder struct {
+	Kind      BarKind
+	Interval  time.Duration
+	Threshold float64
+	Sessions  *SessionCalendar
+	Location  *time.Location
+	Grace     time.Duration
+	BarChan   chan Bar
+	states    map[int64]*barState
+	mu        sync.Mutex
+}
+
+type barState struct {
+	contract  Contract
+	cur       *Bar
+	curPV     float64
+	last      *Bar
+	lastPV    float64
+	lastPrice float64
+	latest    time.Time // newest trade time seen
+	rtVolume  bool
+}
+
+func NewBarBuilder(kind BarKind, interval time.Duration, threshold float64) *BarBuilder {
+	return &BarBuilder{
+		Kind:      kind,
+		Interval:  interval,
+		Threshold: threshold,
+		Location:  time.UTC,
+		Grace:     5 * time.Second,
+		BarChan:   make(chan Bar),
+		states:    make(map[int64]*barState),
+	}
+}
+
+// Handle is a MarketDataHandler that feeds trades to the builder; ticks other
+// than trades are ignored. Register it on the MarketDataDispatcher of the
+// broker.
+func (bb *BarBuilder) Handle(c Contract, t interface{}) {
+	switch r := t.(type) {
+	case TickPrice:
+		if r.TickType == TickLast || r.TickType == TickDelayedLast {
+			bb.setLastPrice(r.Rid, c, r.Price)
+
+			// a trade at a new price arrives as one price tick with its size
+			if r.Size > 0 {
+				bb.lastSize(r.Rid, c, r.Size)
+			}
+		}
+	case TickSize:
+		// a trade at an unchanged price arrives as a size tick alone
+		if r.TickType == TickLastSize || r.TickType == TickDelayedLastSize {
+			bb.lastSize(r.Rid, c, r.Size)
+		}
+	case TickString:
+		if r.TickType == TickRTVolume || r.TickType == TickRTTradeVolume {
+			v, err := r.RTVolume()
+
+			if err != nil {
+				Log.Print("error", err)
+				return
+			}
+
+			bb.AddRTVolume(c, v)
+		}
+	}
+}
+
+// Run flushes time bars every second until it is stopped by closing done.
+func (bb *BarBuilder) Run(done <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			bb.Flush(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (bb *BarBuilder) state(rid int64, c Contract) *barState {
+	s, ok := bb.states[rid]
+
+	if !ok {
+		s = &barState{contract: c}
+		bb.states[rid] = s
+	}
+
+	return s
+}
+
+func (bb *BarBuilder) setLastPrice(rid int64, c Contract, p float64) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	bb.state(rid, c).lastPrice = p
+}
+
+func (bb *BarBuilder) lastSize(rid int64, c Contract, size int64) {
+	bb.mu.Lock()
+	s := bb.state(rid, c)
+	p := s.lastPrice
+	skip := s.rtVolume || p <= 0 || size <= 0
+	bb.mu.Unlock()
+
+	if !skip {
+		bb.AddTrade(rid, c, p, size, time.Now())
+	}
+}
+
+// AddRTVolume adds the trade carried by an RT volume tick. Updates without a
+// trade are ignored.
+func (bb *BarBuilder) AddRTVolume(c Contract, v RTVolume) {
+	bb.mu.Lock()
+	bb.state(v.Rid, c).rtVolume = true
+	bb.mu.Unlock()
+
+	if v.Size <= 0 || v.Price <= 0 {
+		return
+	}
+
+	bb.AddTrade(v.Rid, c, v.Price, v.Size, v.Time)
+}
+
+// AddTrade adds a trade to the bar of request rid.
+func (bb *BarBuilder) AddTrade(rid int64, c Contract, price float64, size int64, t time.Time) {
+	bb.mu.Lock()
+
+	var out []Bar
+	s := bb.state(rid, c)
+
+	if bb.Kind == TimeBars {
+		out = bb.addTimed(rid, s, price, size, t)
+	} else {
+		out = bb.addThreshold(rid, s, price, size, t)
+	}
+
+	bb.mu.Unlock()
+
+	for _, b := range out {
+		bb.BarChan <- b
+	}
+}
+
+func (bb *BarBuilder) addTimed(rid int64, s *barState, price float64, size int64, t time.Time) []Bar {
+	var out []Bar
+
+	start, end := bb.window(t)
+
+	if t.After(s.latest) {
+		s.latest = t
+	}
+
+	switch {
+	case s.cur != nil && start.Equal(s.cur.Start):
+		addToBar(s.cur, &s.curPV, price, size)
+		return nil
+	case s.cur != nil && start.Before(s.cur.Start), s.cur == nil && s.last != nil && !start.After(s.last.Start):
+		// a late trade for a bar that has already been emitted
+		if s.last != nil && start.Equal(s.last.Start) && s.latest.Sub(s.last.End) <= bb.Grace {
+			addToBar(s.last, &s.lastPV, price, size)
+			c := *s.last
+			c.Corrected = true
+			return append(out, c)
+		}
+
+		Log.Print("bars", "dropped late trade for "+FormatContract(&s.contract)+" at "+t.String())
+
+		return nil
+	}
+
+	if s.cur != nil {
+		out = append(out, bb.close(s))
+	}
+
+	s.cur = bb.newBar(rid, s, start, end)
+	addToBar(s.cur, &s.curPV, price, size)
+
+	return out
+}
+
+func (bb *BarBuilder) addThreshold(rid int64, s *barState, price float64, size int64, t time.Time) []Bar {
+	if s.cur == nil {
+		s.cur = bb.newBar(rid, s, t, t)
+	}
+
+	addToBar(s.cur, &s.curPV, price, size)
+	s.cur.End = t
+
+	var n float64
+
+	switch bb.Kind {
+	case TickBars:
+		n = float64(s.cur.BarCount)
+	case VolumeBars:
+		n = float64(s.cur.Volume)
+	case DollarBars:
+		n = s.curPV
+	}
+
+	if n < bb.Threshold {
+		return nil
+	}
+
+	return []Bar{bb.close(s)}
+}
+
+func (bb *BarBuilder) newBar(rid int64, s *barState, start, end time.Time) *Bar {
+	c := s.contract
+	b := &Bar{Start: start, End: end}
+	b.Rid = rid
+	b.Symbol = c.Symbol
+	b.SecurityType = c.SecurityType
+	b.Exchange = c.Exchange
+	b.Currency = c.Currency
+	b.Right = c.Right
+	b.Strike = c.Strike
+	b.Expiry = c.Expiry
+	b.Time = strconv.FormatInt(start.Unix(), 10)
+	s.curPV = 0
+
+	return b
+}
+
+func (bb *BarBuilder) close(s *barState) Bar {
+	b := *s.cur
+	s.last, s.lastPV = s.cur, s.curPV
+	s.cur, s.curPV = nil, 0
+
+	return b
+}
+
+func addToBar(b *Bar, pv *float64, price float64, size int64) {
+	if b.BarCount == 0 {
+		b.Open, b.High, b.Low = price, price, price
+	}
+
+	b.High = math.Max(b.High, price)
+	b.Low = math.Min(b.Low, price)
+	b.Close = price
+	b.Volume += size
+	b.BarCount++
+	*pv += price * float64(size)
+
+	if b.Volume > 0 {
+		b.WAP = *pv / float64(b.Volume)
+	}
+}
+
+// window returns the time bar that contains t.
+func (bb *BarBuilder) window(t time.Time) (time.Time, time.Time) {
+	loc := bb.Location
+
+	if bb.Sessions != nil {
+		loc = bb.Sessions.Location
+
+		if s, ok := bb.Sessions.Session(t); ok {
+			n := t.Sub(s.Start) / bb.Interval
+			start := s.Start.Add(n * bb.Interval)
+			end := start.Add(bb.Interval)
+
+			if end.After(s.End) {
+				end = s.End
+			}
+
+			return start, end
+		}
+	}
+
+	lt := t.In(loc)
+	y, m, d := lt.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	start := midnight.Add(lt.Sub(midnight) / bb.Interval * bb.Interval)
+
+	return start, start.Add(bb.Interval)
+}
+
+// Flush emits every time bar that ended before now. Run calls it every
+// second; callers feeding trades with AddTrade should call it themselves.
+func (bb *BarBuilder) Flush(now time.Time) {
+	if bb.Kind != TimeBars {
+		return
+	}
+
+	bb.mu.Lock()
+
+	var out []Bar
+
+	for _, s := range bb.states {
+		if s.cur != nil && !now.Before(s.cur.End) {
+			out = append(out, bb.close(s))
+		}
+	}
+
+	bb.mu.Unlock()
+
+	for _, b := range out {
+		bb.BarChan <- b
+	}
+}
+
+// Remove forgets the state of a cancelled request without emitting its bar.
+func (bb *BarBuilder) Remove(rid int64) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	delete(bb.states, rid)
+}
@@ -0,0 +1,387 @@
+package ib
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// BOOK ANALYTICS
+////////////////////////////////////////////////////////////////////////////////
+
+// DepthPoint is a point of a cumulative depth curve: the size available at
+// Price or better.
+type DepthPoint struct {
+	Price      float64
+	Cumulative int64
+}
+
+// Imbalance returns (bid size - ask size) / (bid size + ask size) over the
+// first levels levels of each side, from -1 (all asks) to 1 (all bids).
+func (s *BookSnapshot) Imbalance(levels int) float64 {
+	return imbalance(s.Bids, s.Asks, levels)
+}
+
+// Microprice returns the mid price weighted by the size on the opposite
+// side, which leans towards the side more likely to trade next.
+func (s *BookSnapshot) Microprice() (float64, bool) {
+	return microprice(s.Bids, s.Asks)
+}
+
+// WeightedMid returns the midpoint of the size-weighted average bid and ask
+// prices over the first levels levels.
+func (s *BookSnapshot) WeightedMid(levels int) (float64, bool) {
+	return weightedMid(s.Bids, s.Asks, levels)
+}
+
+func (s *BookSnapshot) Spread() (float64, bool) {
+	if len(s.Bids) == 0 || len(s.Asks) == 0 {
+		return 0, false
+	}
+
+	return s.Asks[0].Price - s.Bids[0].Price, true
+}
+
+// SpreadTicks returns the spread as a number of minimum ticks.
+func (s *BookSnapshot) SpreadTicks(tick float64) (float64, bool) {
+	sp, ok := s.Spread()
+
+	if !ok || tick <= 0 {
+		return 0, false
+	}
+
+	return math.Round(sp/tick*1e6) / 1e6, true
+}
+
+// FillPrice returns the average price at which an order for quantity would
+// fill against the book, consuming asks for "BUY" and bids otherwise, and the
+// quantity the book can fill.
+func (s *BookSnapshot) FillPrice(action string, quantity int64) (float64, int64) {
+	if strings.ToUpper(action) == "BUY" {
+		return fillPrice(s.Asks, quantity)
+	}
+
+	return fillPrice(s.Bids, quantity)
+}
+
+// Slippage returns how much worse than the mid price the average fill of an
+// order for quantity would be, together with the quantity the book can fill.
+func (s *BookSnapshot) Slippage(action string, quantity int64) (float64, int64) {
+	return slippage(s.Bids, s.Asks, action, quantity)
+}
+
+// DepthCurve returns the cumulative size of one side, best price first.
+func (s *BookSnapshot) DepthCurve(side int64) []DepthPoint {
+	if side == DepthBid {
+		return depthCurve(s.Bids)
+	}
+
+	return depthCurve(s.Asks)
+}
+
+func sumSize(l []BookLevel, levels int) (int64, float64) {
+	var size int64
+	var pv float64
+
+	for i := 0; i < len(l) && i < levels; i++ {
+		size += l[i].Size
+		pv += l[i].Price * float64(l[i].Size)
+	}
+
+	return size, pv
+}
+
+func imbalance(bids, asks []BookLevel, levels int) float64 {
+	b, _ := sumSize(bids, levels)
+	a, _ := sumSize(asks, levels)
+
+	if a+b == 0 {
+		return 0
+	}
+
+	return float64(b-a) / float64(b+a)
+}
+
+func microprice(bids, asks []BookLevel) (float64, bool) {
+	if len(bids) == 0 || len(asks) == 0 {
+		return 0, false
+	}
+
+	b, a := bids[0], asks[0]
+
+	if b.Size+a.Size == 0 {
+		return (b.Price + a.Price) / 2, true
+	}
+
+	return (b.Price*float64(a.Size) + a.Price*float64(b.Size)) / float64(b.Size+a.Size), true
+}
+
+func weightedMid(bids, asks []BookLevel, levels int) (float64, bool) {
+	b, bpv := sumSize(bids, levels)
+	a, apv := sumSize(asks, levels)
+
+	if b == 0 || a == 0 {
+		return 0, false
+	}
+
+	return (bpv/float64(b) + apv/float64(a)) / 2, true
+}
+
+func fillPrice(l []BookLevel, quantity int64) (float64, int64) {
+	var filled int64
+	var pv float64
+
+	for _, lv := range l {
+		if filled >= quantity {
+			break
+		}
+
+		n := lv.Size
+
+		if filled+n > quantity {
+			n = quantity - filled
+		}
+
+		filled += n
+		pv += lv.Price * float64(n)
+	}
+
+	if filled == 0 {
+		return 0, 0
+	}
+
+	return pv / float64(filled), filled
+}
+
+func slippage(bids, asks []BookLevel, action string, quantity int64) (float64, int64) {
+	if len(bids) == 0 || len(asks) == 0 {
+		return 0, 0
+	}
+
+	mid := (bids[0].Price + asks[0].Price) / 2
+
+	if strings.ToUpper(action) == "BUY" {
+		p, n := fillPrice(asks, quantity)
+		return p - mid, n
+	}
+
+	p, n := fillPrice(bids, quantity)
+
+	return mid - p, n
+}
+
+func depthCurve(l []BookLevel) []DepthPoint {
+	r := make([]DepthPoint, len(l))
+
+	var cum int64
+
+	for i, lv := range l {
+		cum += lv.Size
+		r[i] = DepthPoint{lv.Price, cum}
+	}
+
+	return r
+}
+
+// BookStats are the signals of one book after a depth update.
+type BookStats struct {
+	Rid            int64
+	BidPrice       float64
+	BidSize        int64
+	AskPrice       float64
+	AskSize        int64
+	Spread         float64
+	SpreadTicks    float64 // 0 when the minimum tick is unknown
+	Mid            float64
+	Microprice     float64
+	WeightedMid    float64 // over Levels levels
+	Imbalance      float64 // top of book
+	DepthImbalance float64 // over Levels levels
+	BidDepth       int64   // total size of every bid level
+	AskDepth       int64
+	Updated        time.Time
+}
+
+// BookAnalytics recomputes the BookStats of every book of an OrderBooks on
+// each change it reports, so that books are resubscribed and resent in one
+// place. Total depth is kept incrementally from the size each operation adds
+// or removes. Minimum ticks for SpreadTicks are looked up in TickRules. No
+// stats are published while a book is stale. When StatsChan is not nil every
+// update is sent on it and must be received.
+type BookAnalytics struct {
+	Books     *OrderBooks
+	Levels    int
+	TickRules *TickRules
+	StatsChan chan BookStats
+	depth     map[int64]*bookDepth
+	stats     map[int64]BookStats
+	mu        sync.Mutex
+}
+
+type bookDepth struct {
+	bid int64
+	ask int64
+}
+
+// NewBookAnalytics registers the analytics on the change notifications of
+// books.
+func NewBookAnalytics(books *OrderBooks, levels int, notify bool) *BookAnalytics {
+	a := &BookAnalytics{
+		Books:     books,
+		Levels:    levels,
+		TickRules: NewTickRules(),
+		depth:     make(map[int64]*bookDepth),
+		stats:     make(map[int64]BookStats),
+	}
+
+	if notify {
+		a.StatsChan = make(chan BookStats)
+	}
+
+	books.Register(a.Handle)
+
+	return a
+}
+
+// Handle is a BookChangeHandler that updates the depth and stats of the
+// changed book.
+func (a *BookAnalytics) Handle(c BookChange) {
+	rid := c.Rid
+
+	a.mu.Lock()
+
+	if c.Reset {
+		delete(a.depth, c.OldRid)
+		delete(a.stats, c.OldRid)
+		delete(a.depth, rid)
+		delete(a.stats, rid)
+	}
+
+	d, ok := a.depth[rid]
+
+	if !ok {
+		d = &bookDepth{}
+		a.depth[rid] = d
+	}
+
+	if !c.Reset {
+		var delta int64
+
+		switch c.Operation {
+		case DepthInsert:
+			delta = c.Level.Size
+		case DepthUpdate:
+			delta = c.Level.Size - c.Previous.Size
+		case DepthDelete:
+			delta = -c.Previous.Size
+		}
+
+		if c.Side == DepthBid {
+			d.bid += delta
+		} else {
+			d.ask += delta
+		}
+	}
+
+	if c.Stale {
+		delete(a.stats, rid)
+		a.mu.Unlock()
+		return
+	}
+
+	st := a.compute(&c.Book, d)
+	a.stats[rid] = st
+
+	a.mu.Unlock()
+
+	if a.StatsChan != nil {
+		a.StatsChan <- st
+	}
+}
+
+func (a *BookAnalytics) compute(s *BookSnapshot, d *bookDepth) BookStats {
+	st := BookStats{
+		Rid:      s.Rid,
+		BidDepth: d.bid,
+		AskDepth: d.ask,
+		Updated:  s.Updated,
+	}
+
+	levels := a.Levels
+
+	if levels <= 0 {
+		levels = 1
+	}
+
+	if len(s.Bids) > 0 {
+		st.BidPrice, st.BidSize = s.Bids[0].Price, s.Bids[0].Size
+	}
+
+	if len(s.Asks) > 0 {
+		st.AskPrice, st.AskSize = s.Asks[0].Price, s.Asks[0].Size
+	}
+
+	st.Imbalance = imbalance(s.Bids, s.Asks, 1)
+	st.DepthImbalance = imbalance(s.Bids, s.Asks, levels)
+	st.Microprice, _ = microprice(s.Bids, s.Asks)
+	st.WeightedMid, _ = weightedMid(s.Bids, s.Asks, levels)
+
+	if len(s.Bids) > 0 && len(s.Asks) > 0 {
+		st.Spread = st.AskPrice - st.BidPrice
+		st.Mid = (st.AskPrice + st.BidPrice) / 2
+
+		if t, err := a.TickRules.Get(&s.Contract); err == nil && t.MinTick > 0 {
+			st.SpreadTicks = math.Round(st.Spread/t.MinTick*1e6) / 1e6
+		}
+	}
+
+	return st
+}
+
+// Stats returns the latest stats of rid, which are missing while its book is
+// stale.
+func (a *BookAnalytics) Stats(rid int64) (BookStats, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, ok := a.stats[rid]
+
+	return st, ok
+}
+
+// Book returns a snapshot of the book of rid, for FillPrice, Slippage and
+// DepthCurve.
+func (a *BookAnalytics) Book(rid int64) (BookSnapshot, bool) {
+	b, ok := a.Books.Book(rid)
+
+	if !ok {
+		return BookSnapshot{}, false
+	}
+
+	s, err := b.Snapshot()
+
+	return s, err == nil
+}
+
+// Slippage returns the expected slippage of an order for quantity against
+// the current book of rid.
+func (a *BookAnalytics) Slippage(rid int64, action string, quantity int64) (float64, int64) {
+	s, ok := a.Book(rid)
+
+	if !ok {
+		return 0, 0
+	}
+
+	return s.Slippage(action, quantity)
+}
+
+// Remove forgets the stats of a cancelled request.
+func (a *BookAnalytics) Remove(rid int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.depth, rid)
+	delete(a.stats, rid)
+}
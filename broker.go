@@ -3,10 +3,13 @@ package ib
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"math/rand"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	//	"errors"
@@ -28,9 +31,10 @@ func NextClientId() int64 {
 	return CLIENT_ID_INCR
 }
 
+// NextReqId returns a new request id. It is safe to call from several
+// goroutines, e.g. a dispatcher resubscribing while callers send requests.
 func (b *Broker) NextReqId() int64 {
-	b.Rid += 1
-	return b.Rid
+	return atomic.AddInt64(&b.Rid, 1)
 }
 
 func (b *Broker) Initialize() {
@@ -120,7 +124,7 @@ func (b *Broker) ReadString() (string, error) {
 func (b *Broker) ReadInt() (int64, error) {
 	str, err := b.ReadString()
 
-	if err != nil {
+	if err != nil || str == "" {
 		return 0, err
 	}
 
@@ -130,7 +134,7 @@ func (b *Broker) ReadInt() (int64, error) {
 func (b *Broker) ReadFloat() (float64, error) {
 	str, err := b.ReadString()
 
-	if err != nil {
+	if err != nil || str == "" {
 		return 0, err
 	}
 
@@ -150,3 +154,103 @@ func (b *Broker) ReadBool() (bool, error) {
 
 	return false, err
 }
+
+// ReadFields reads one field into each of v, which must be pointers to string,
+// int64, float64 or bool. Every field is consumed even when one fails to
+// parse, so the stream stays aligned, and the first error is returned.
+func (b *Broker) ReadFields(v ...interface{}) error {
+	var first error
+
+	for i := range v {
+		var err error
+
+		switch p := v[i].(type) {
+		case *string:
+			*p, err = b.ReadString()
+		case *int64:
+			*p, err = b.ReadInt()
+		case *float64:
+			*p, err = b.ReadFloat()
+		case *bool:
+			*p, err = b.ReadBool()
+		default:
+			err = fmt.Errorf("cannot read field into %T", p)
+		}
+
+		if err != nil && first == nil {
+			first = fmt.Errorf("field %d: %v", i, err)
+		}
+	}
+
+	return first
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ERRORS
+////////////////////////////////////////////////////////////////////////////////
+
+// WARNING_CODES lists ErrMsg codes that report on a request without ending
+// it, such as 10167 (delayed data is shown instead) and 10090 (part of the
+// requested data is not subscribed). Codes 2100 to 2169 are warnings as well.
+var WARNING_CODES = map[int64]bool{
+	10090: true,
+	10167: true,
+}
+
+// ReadErrMsg reads the request id, code and message of an ErrMsg. The id is
+// -1 for messages about the connection rather than a request.
+func (b *Broker) ReadErrMsg(version string) (int64, int64, string) {
+	id, _ := b.ReadInt()
+	code, _ := b.ReadInt()
+	msg, _ := b.ReadString()
+
+	return id, code, msg
+}
+
+func IsWarningCode(code int64) bool {
+	return WARNING_CODES[code] || (code >= 2100 && code <= 2169)
+}
+
+// DISCARD_TTL is how long responses to an abandoned request are discarded.
+var DISCARD_TTL = time.Minute
+
+// discardSet remembers abandoned request ids for DISCARD_TTL, so that
+// responses still in flight are dropped instead of being delivered as if
+// nobody had asked for them. Expired ids are pruned as new ones are added.
+type discardSet struct {
+	ids map[int64]time.Time
+	mu  sync.Mutex
+}
+
+func newDiscardSet() *discardSet {
+	return &discardSet{ids: make(map[int64]time.Time)}
+}
+
+func (d *discardSet) Add(id int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	for k, t := range d.ids {
+		if now.After(t) {
+			delete(d.ids, k)
+		}
+	}
+
+	d.ids[id] = now.Add(DISCARD_TTL)
+}
+
+func (d *discardSet) Has(id int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.ids[id]
+
+	if ok && time.Now().After(t) {
+		delete(d.ids, id)
+		return false
+	}
+
+	return ok
+}
@@ -1,5 +1,13 @@
 package ib
 
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
 type Contract struct {
 	ContractId      int64
 	Symbol          string
@@ -20,3 +28,256 @@ type Contract struct {
 	//	ComboLegs []ComboLeg
 	//	UnderComp UnderComp
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// IDENTITY
+////////////////////////////////////////////////////////////////////////////////
+
+// ContractKey identifies an instrument. When the contract id is known it is
+// the whole key; otherwise the key is the normalized spec of the instrument.
+// Routing fields (Exchange, PrimaryExchange) and LocalSymbol are not part of
+// the key, so keys can be compared with == and used as map keys. The trading
+// class is, since it tells apart derivatives such as SPX and SPXW options
+// that share everything else.
+type ContractKey struct {
+	ContractId   int64
+	Symbol       string
+	SecurityType string
+	Expiry       string
+	Strike       float64
+	Right        string
+	TradingClass string
+	Multiplier   string
+	Currency     string
+}
+
+func (c *Contract) Key() ContractKey {
+	if c.ContractId != 0 {
+		return ContractKey{ContractId: c.ContractId}
+	}
+
+	return c.SpecKey()
+}
+
+// SpecKey returns the normalized spec of the contract, ignoring its id.
+func (c *Contract) SpecKey() ContractKey {
+	k := ContractKey{
+		Symbol:       strings.ToUpper(strings.TrimSpace(c.Symbol)),
+		SecurityType: strings.ToUpper(strings.TrimSpace(c.SecurityType)),
+		Currency:     strings.ToUpper(strings.TrimSpace(c.Currency)),
+	}
+
+	switch k.SecurityType {
+	case "FUT", "OPT", "FOP", "WAR", "IOPT":
+		k.Expiry = strings.TrimSpace(c.Expiry)
+		k.TradingClass = strings.ToUpper(strings.TrimSpace(c.TradingClass))
+		k.Multiplier = normalizeMultiplier(c.Multiplier)
+	}
+
+	switch k.SecurityType {
+	case "OPT", "FOP", "WAR", "IOPT":
+		k.Strike = c.Strike
+		k.Right = normalizeRight(c.Right)
+	}
+
+	return k
+}
+
+// normalizeMultiplier writes numeric multipliers in their shortest form, so
+// that "100" and "100.0" match, and treats a zero multiplier as blank, as
+// ContractDetails.Contract does.
+func normalizeMultiplier(m string) string {
+	m = strings.TrimSpace(m)
+
+	f, err := strconv.ParseFloat(m, 64)
+
+	if err != nil {
+		return m
+	}
+
+	return formatMultiplier(f)
+}
+
+func normalizeRight(r string) string {
+	switch strings.ToUpper(strings.TrimSpace(r)) {
+	case "C", "CALL":
+		return "C"
+	case "P", "PUT":
+		return "P"
+	default:
+		return strings.ToUpper(strings.TrimSpace(r))
+	}
+}
+
+// Equal reports whether c and o are the same instrument. Contract ids are
+// compared when both are known and specs otherwise.
+func (c *Contract) Equal(o *Contract) bool {
+	if c.ContractId != 0 && o.ContractId != 0 {
+		return c.ContractId == o.ContractId
+	}
+
+	return c.SpecKey() == o.SpecKey()
+}
+
+func (k ContractKey) Hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(k.String()))
+	return h.Sum64()
+}
+
+// String returns the compact text form of the key, e.g. "AAPL STK USD",
+// "ES FUT 20160318 x50 USD", "SPX OPT 20160415 2000 C /SPXW x100 USD" or
+// "#265598".
+func (k ContractKey) String() string {
+	c := k.Contract()
+	return FormatContract(&c)
+}
+
+func (k ContractKey) Contract() Contract {
+	return Contract{
+		ContractId:   k.ContractId,
+		Symbol:       k.Symbol,
+		SecurityType: k.SecurityType,
+		Expiry:       k.Expiry,
+		Strike:       k.Strike,
+		Right:        k.Right,
+		TradingClass: k.TradingClass,
+		Multiplier:   k.Multiplier,
+		Currency:     k.Currency,
+	}
+}
+
+func ParseContractKey(s string) (ContractKey, error) {
+	c, err := ParseContract(s)
+
+	if err != nil {
+		return ContractKey{}, err
+	}
+
+	return c.Key(), nil
+}
+
+// FormatContract returns the compact text form of a contract:
+//
+//	SYMBOL SECTYPE [EXPIRY [STRIKE RIGHT]] [/CLASS] [xMULTIPLIER] [EXCHANGE] CURRENCY
+//
+// Expiry and trading class are written for FUT, OPT, FOP, WAR and IOPT,
+// strike and right for the option types. Spaces in the symbol are written as "_". A contract with a
+// known id is written as "#ID [EXCHANGE]".
+func FormatContract(c *Contract) string {
+	if c.ContractId != 0 {
+		if c.Exchange != "" {
+			return "#" + strconv.FormatInt(c.ContractId, 10) + " " + c.Exchange
+		}
+		return "#" + strconv.FormatInt(c.ContractId, 10)
+	}
+
+	k := c.SpecKey()
+	f := []string{strings.Replace(k.Symbol, " ", "_", -1), k.SecurityType}
+
+	switch k.SecurityType {
+	case "FUT", "OPT", "FOP", "WAR", "IOPT":
+		f = append(f, k.Expiry)
+	}
+
+	switch k.SecurityType {
+	case "OPT", "FOP", "WAR", "IOPT":
+		f = append(f, strconv.FormatFloat(k.Strike, 'f', -1, 64), k.Right)
+	}
+
+	if k.TradingClass != "" {
+		f = append(f, "/"+k.TradingClass)
+	}
+
+	if k.Multiplier != "" {
+		f = append(f, "x"+k.Multiplier)
+	}
+
+	if c.Exchange != "" {
+		f = append(f, strings.ToUpper(c.Exchange))
+	}
+
+	f = append(f, k.Currency)
+
+	return strings.Join(f, " ")
+}
+
+// ParseContract parses the text form written by FormatContract, such as
+// "AAPL STK SMART USD".
+func ParseContract(s string) (Contract, error) {
+	var c Contract
+
+	f := strings.Fields(s)
+
+	if len(f) == 0 {
+		return c, errors.New("empty contract")
+	}
+
+	if strings.HasPrefix(f[0], "#") {
+		id, err := strconv.ParseInt(f[0][1:], 10, 64)
+
+		if err != nil || len(f) > 2 {
+			return c, fmt.Errorf("invalid contract %q", s)
+		}
+
+		c.ContractId = id
+
+		if len(f) == 2 {
+			c.Exchange = f[1]
+		}
+
+		return c, nil
+	}
+
+	if len(f) < 3 {
+		return c, fmt.Errorf("invalid contract %q", s)
+	}
+
+	c.Symbol = strings.Replace(f[0], "_", " ", -1)
+	c.SecurityType = strings.ToUpper(f[1])
+	c.Currency = f[len(f)-1]
+	rest := f[2 : len(f)-1]
+
+	switch c.SecurityType {
+	case "FUT", "OPT", "FOP", "WAR", "IOPT":
+		if len(rest) == 0 {
+			return c, fmt.Errorf("invalid contract %q: missing expiry", s)
+		}
+		c.Expiry, rest = rest[0], rest[1:]
+	}
+
+	switch c.SecurityType {
+	case "OPT", "FOP", "WAR", "IOPT":
+		if len(rest) < 2 {
+			return c, fmt.Errorf("invalid contract %q: missing strike or right", s)
+		}
+
+		strike, err := strconv.ParseFloat(rest[0], 64)
+
+		if err != nil {
+			return c, fmt.Errorf("invalid contract %q: %v", s, err)
+		}
+
+		c.Strike = strike
+		c.Right = normalizeRight(rest[1])
+		rest = rest[2:]
+	}
+
+	if len(rest) > 0 && strings.HasPrefix(rest[0], "/") {
+		c.TradingClass, rest = rest[0][1:], rest[1:]
+	}
+
+	if len(rest) > 0 && strings.HasPrefix(rest[0], "x") {
+		c.Multiplier, rest = rest[0][1:], rest[1:]
+	}
+
+	if len(rest) > 0 {
+		c.Exchange, rest = rest[0], rest[1:]
+	}
+
+	if len(rest) > 0 {
+		return c, fmt.Errorf("invalid contract %q", s)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,187 @@
+package ib
+
+import "testing"
+
+func TestFormatContract(t *testing.T) {
+	tests := []struct {
+		c    Contract
+		want string
+	}{
+		{Contract{Symbol: "AAPL", SecurityType: "STK", Exchange: "SMART", Currency: "USD"}, "AAPL STK SMART USD"},
+		{Contract{Symbol: "aapl", SecurityType: "stk", Currency: "usd"}, "AAPL STK USD"},
+		{Contract{Symbol: "BRK B", SecurityType: "STK", Exchange: "NYSE", Currency: "USD"}, "BRK_B STK NYSE USD"},
+		{Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Multiplier: "50", Exchange: "GLOBEX", Currency: "USD"}, "ES FUT 20160318 x50 GLOBEX USD"},
+		{Contract{Symbol: "AAPL", SecurityType: "OPT", Expiry: "20160415", Strike: 110, Right: "CALL", Multiplier: "100", Currency: "USD"}, "AAPL OPT 20160415 110 C x100 USD"},
+		{Contract{Symbol: "ES", SecurityType: "FOP", Expiry: "20160318", Strike: 2012.5, Right: "P", Exchange: "globex", Currency: "USD"}, "ES FOP 20160318 2012.5 P GLOBEX USD"},
+		{Contract{Symbol: "EUR", SecurityType: "CASH", Expiry: "20160318", Exchange: "IDEALPRO", Currency: "USD"}, "EUR CASH IDEALPRO USD"},
+		{Contract{ContractId: 265598, Symbol: "AAPL", SecurityType: "STK"}, "#265598"},
+		{Contract{ContractId: 265598, Exchange: "SMART"}, "#265598 SMART"},
+		{Contract{Symbol: "SPX", SecurityType: "OPT", Expiry: "20160415", Strike: 2000, Right: "C", TradingClass: "spxw", Multiplier: "100.0", Currency: "USD"}, "SPX OPT 20160415 2000 C /SPXW x100 USD"},
+		{Contract{Symbol: "AAPL", SecurityType: "STK", TradingClass: "NMS", Currency: "USD"}, "AAPL STK USD"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatContract(&tt.c); got != tt.want {
+			t.Errorf("FormatContract(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestParseContractRoundTrip(t *testing.T) {
+	tests := []string{
+		"AAPL STK USD",
+		"AAPL STK SMART USD",
+		"BRK_B STK NYSE USD",
+		"ES FUT 20160318 GLOBEX USD",
+		"ES FUT 20160318 x50 GLOBEX USD",
+		"AAPL OPT 20160415 110 C x100 USD",
+		"AAPL OPT 20160415 110.5 P SMART USD",
+		"ES FOP 20160318 2012.5 C x50 GLOBEX USD",
+		"SPX OPT 20160415 2000 C /SPXW x100 CBOE USD",
+		"EUR CASH IDEALPRO USD",
+		"#265598",
+		"#265598 SMART",
+	}
+
+	for _, s := range tests {
+		c, err := ParseContract(s)
+
+		if err != nil {
+			t.Errorf("ParseContract(%q): %v", s, err)
+			continue
+		}
+
+		if got := FormatContract(&c); got != s {
+			t.Errorf("FormatContract(ParseContract(%q)) = %q", s, got)
+		}
+
+		k, err := ParseContractKey(s)
+
+		if err != nil {
+			t.Errorf("ParseContractKey(%q): %v", s, err)
+			continue
+		}
+
+		if k != c.Key() {
+			t.Errorf("ParseContractKey(%q) = %+v, want %+v", s, k, c.Key())
+		}
+	}
+}
+
+func TestParseContractFields(t *testing.T) {
+	c, err := ParseContract("BRK_B OPT 20160415 140 call x100 SMART USD")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Contract{
+		Symbol:       "BRK B",
+		SecurityType: "OPT",
+		Expiry:       "20160415",
+		Strike:       140,
+		Right:        "C",
+		Multiplier:   "100",
+		Exchange:     "SMART",
+		Currency:     "USD",
+	}
+
+	if c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestParseContractInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"AAPL",
+		"AAPL STK",
+		"#abc",
+		"#265598 SMART USD",
+		"ES FUT USD",
+		"AAPL OPT 20160415 C USD",
+		"AAPL OPT 20160415 abc C USD",
+		"AAPL STK SMART NASDAQ USD",
+	} {
+		if _, err := ParseContract(s); err == nil {
+			t.Errorf("ParseContract(%q): expected an error", s)
+		}
+	}
+}
+
+func TestContractEqual(t *testing.T) {
+	spx := Contract{Symbol: "SPX", SecurityType: "OPT", Expiry: "20160415", Strike: 2000, Right: "C", TradingClass: "SPX", Multiplier: "100", Exchange: "CBOE", Currency: "USD"}
+	spxw := spx
+	spxw.TradingClass = "SPXW"
+
+	tests := []struct {
+		name  string
+		a, b  Contract
+		equal bool
+	}{
+		{
+			name:  "exchange is ignored",
+			a:     Contract{Symbol: "AAPL", SecurityType: "STK", Exchange: "SMART", Currency: "USD"},
+			b:     Contract{Symbol: "AAPL", SecurityType: "STK", Exchange: "NASDAQ", PrimaryExchange: "NASDAQ", Currency: "USD"},
+			equal: true,
+		},
+		{
+			name:  "local symbol is ignored",
+			a:     Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Multiplier: "50", Currency: "USD", LocalSymbol: "ESH6"},
+			b:     Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Multiplier: "50", Currency: "USD"},
+			equal: true,
+		},
+		{
+			name:  "case, spaces and rights are normalized",
+			a:     Contract{Symbol: " aapl", SecurityType: "opt", Expiry: "20160415", Strike: 110, Right: "CALL", Currency: "usd"},
+			b:     Contract{Symbol: "AAPL", SecurityType: "OPT", Expiry: "20160415", Strike: 110, Right: "C", Currency: "USD"},
+			equal: true,
+		},
+		{
+			name:  "multipliers are compared as numbers",
+			a:     Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Multiplier: "50.0", Currency: "USD"},
+			b:     Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Multiplier: "50", Currency: "USD"},
+			equal: true,
+		},
+		{
+			name:  "zero multiplier is blank",
+			a:     Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Multiplier: "0", Currency: "USD"},
+			b:     Contract{Symbol: "ES", SecurityType: "FUT", Expiry: "20160318", Currency: "USD"},
+			equal: true,
+		},
+		{
+			name:  "trading class tells SPX from SPXW",
+			a:     spx,
+			b:     spxw,
+			equal: false,
+		},
+		{
+			name:  "trading class is ignored for stocks",
+			a:     Contract{Symbol: "AAPL", SecurityType: "STK", TradingClass: "NMS", Currency: "USD"},
+			b:     Contract{Symbol: "AAPL", SecurityType: "STK", Currency: "USD"},
+			equal: true,
+		},
+		{
+			name:  "different strikes",
+			a:     Contract{Symbol: "AAPL", SecurityType: "OPT", Expiry: "20160415", Strike: 110, Right: "C", Currency: "USD"},
+			b:     Contract{Symbol: "AAPL", SecurityType: "OPT", Expiry: "20160415", Strike: 115, Right: "C", Currency: "USD"},
+			equal: false,
+		},
+		{
+			name:  "contract ids win over specs",
+			a:     Contract{ContractId: 265598, Symbol: "AAPL", SecurityType: "STK", Currency: "USD"},
+			b:     Contract{ContractId: 265598, Symbol: "AAPL", SecurityType: "STK", Exchange: "SMART", Currency: "EUR"},
+			equal: true,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.a.Equal(&tt.b); got != tt.equal {
+			t.Errorf("%s: Equal = %v, want %v", tt.name, got, tt.equal)
+		}
+
+		if got := tt.a.Key().Hash() == tt.b.Key().Hash(); got != tt.equal {
+			t.Errorf("%s: equal hashes = %v, want %v", tt.name, got, tt.equal)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package ib
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,7 +25,12 @@ func init() {
 	REQUEST_VERSION["ContractDetails"] = 7
 }
 
+// Send registers the request under id and sends it. Requests may be sent from
+// several goroutines.
 func (r *ContractDetailsRequest) Send(id int64, b *ContractDetailsBroker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	b.Contracts[id] = r.Contract
 	b.WriteInt(REQUEST_CODE["ContractDetails"])
 	b.WriteInt(REQUEST_VERSION["ContractDetails"])
@@ -61,8 +70,8 @@ type ContractDetails struct {
 	MarketName           string
 	TradingClass         string
 	ContractId           int64
-	MinTick              int64
-	Multiplier           int64
+	MinTick              float64
+	Multiplier           float64
 	OrderTypes           string
 	ValidExchanges       string
 	PriceMagnifier       int64
@@ -86,14 +95,111 @@ func init() {
 	RESPONSE_CODE["ContractDetails"] = "10"
 }
 
+func (d *ContractDetails) Contract() Contract {
+	c := Contract{
+		ContractId:      d.ContractId,
+		Symbol:          d.Symbol,
+		SecurityType:    d.SecurityType,
+		Expiry:          d.Expiry,
+		Strike:          d.Strike,
+		Right:           d.Right,
+		Exchange:        d.Exchange,
+		Currency:        d.Currency,
+		LocalSymbol:     d.LocalSymbol,
+		TradingClass:    d.TradingClass,
+		PrimaryExchange: d.PrimaryExchange,
+	}
+
+	if d.Multiplier != 0 {
+		c.Multiplier = strconv.FormatFloat(d.Multiplier, 'f', -1, 64)
+	}
+
+	return c
+}
+
+func (d *ContractDetails) Key() ContractKey {
+	return ContractKey{ContractId: d.ContractId}
+}
+
+// SecId returns the identifier of the given type, e.g. "ISIN", from SecIdList.
+func (d *ContractDetails) SecId(secIdType string) (string, bool) {
+	for _, tv := range d.SecIdList {
+		if strings.EqualFold(tv.Tag, secIdType) {
+			return tv.Value, true
+		}
+	}
+
+	return "", false
+}
+
+type BondContractDetails struct {
+	Rid                 int64
+	Symbol              string
+	SecurityType        string
+	Cusip               string
+	Coupon              float64
+	Maturity            string
+	IssueDate           string
+	Ratings             string
+	BondType            string
+	CouponType          string
+	Convertible         bool
+	Callable            bool
+	Puttable            bool
+	DescAppend          string
+	Exchange            string
+	Currency            string
+	MarketName          string
+	TradingClass        string
+	ContractId          int64
+	MinTick             float64
+	OrderTypes          string
+	ValidExchanges      string
+	NextOptionDate      string
+	NextOptionType      string
+	NextOptionPartial   bool
+	Notes               string
+	LongName            string
+	EconValueRule       string
+	EconValueMultiplier float64
+	SecIdListCount      int64
+	SecIdList           []TagValue
+}
+
+func init() {
+	RESPONSE_CODE["BondContractDetails"] = "18"
+}
+
+type ContractDetailsEnd struct {
+	Rid int64
+}
+
+func init() {
+	RESPONSE_CODE["ContractDetailsEnd"] = "52"
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // BROKER
 ////////////////////////////////////////////////////////////////////////////////
 
 type ContractDetailsBroker struct {
 	Broker
-	Contracts           map[int64]Contract
-	ContractDetailsChan chan ContractDetails
+	Contracts               map[int64]Contract
+	ContractDetailsChan     chan ContractDetails
+	BondContractDetailsChan chan BondContractDetails
+	ContractDetailsEndChan  chan ContractDetailsEnd
+	pending                 map[int64]*contractDetailsWaiter
+	discarded               *discardSet
+	mu                      *sync.Mutex
+}
+
+// contractDetailsWaiter collects the responses for a request issued by Fetch
+// so that they are not delivered on the broker channels.
+type contractDetailsWaiter struct {
+	details []ContractDetails
+	bonds   []BondContractDetails
+	err     error
+	done    chan struct{}
 }
 
 func NewContractDetailsBroker() ContractDetailsBroker {
@@ -101,6 +207,11 @@ func NewContractDetailsBroker() ContractDetailsBroker {
 		Broker{},
 		make(map[int64]Contract),
 		make(chan ContractDetails),
+		make(chan BondContractDetails),
+		make(chan ContractDetailsEnd),
+		make(map[int64]*contractDetailsWaiter),
+		newDiscardSet(),
+		&sync.Mutex{},
 	}
 	b.Broker.Initialize()
 	return b
@@ -114,63 +225,372 @@ func (b *ContractDetailsBroker) Listen() {
 			continue
 		}
 
-		if s == RESPONSE_CODE["ContractDetails"] {
+		switch s {
+		case RESPONSE_CODE["ContractDetails"]:
 			version, err := b.ReadString()
 
 			if err != nil {
 				continue
 			}
 
-			c := b.ReadContractDetails(version)
+			c, err := b.ReadContractDetails(version)
+
+			if err != nil {
+				b.fail(c.Rid, err)
+				continue
+			}
+
+			if w := b.waiter(c.Rid); w != nil {
+				w.details = append(w.details, c)
+				continue
+			}
+
+			if b.discarded.Has(c.Rid) {
+				continue
+			}
+
 			b.ContractDetailsChan <- c
+		case RESPONSE_CODE["BondContractDetails"]:
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			c, err := b.ReadBondContractDetails(version)
+
+			if err != nil {
+				b.fail(c.Rid, err)
+				continue
+			}
+
+			if w := b.waiter(c.Rid); w != nil {
+				w.bonds = append(w.bonds, c)
+				continue
+			}
+
+			if b.discarded.Has(c.Rid) {
+				continue
+			}
+
+			b.BondContractDetailsChan <- c
+		case RESPONSE_CODE["ContractDetailsEnd"]:
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			r := b.ReadContractDetailsEnd(version)
+
+			if w := b.release(r.Rid); w != nil {
+				close(w.done)
+				continue
+			}
+
+			if b.discarded.Has(r.Rid) {
+				continue
+			}
+
+			b.ContractDetailsEndChan <- r
+		case RESPONSE_CODE["ErrMsg"]:
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			id, code, msg := b.ReadErrMsg(version)
+
+			if IsWarningCode(code) {
+				continue
+			}
+
+			b.fail(id, fmt.Errorf("contract details request %d: error %d: %s", id, code, msg))
+		}
+	}
+}
+
+// Fetch sends a ContractDetailsRequest for c and blocks until every matching
+// contract has been received or ctx is done. Listen must be running.
+func (b *ContractDetailsBroker) Fetch(ctx context.Context, c Contract) ([]ContractDetails, error) {
+	w, err := b.fetch(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(w.details) == 0 {
+		return nil, errors.New("contract details request returned no contracts")
+	}
+
+	return w.details, nil
+}
+
+// FetchBond is Fetch for BOND contracts, which the gateway answers with
+// BondContractDetails.
+func (b *ContractDetailsBroker) FetchBond(ctx context.Context, c Contract) ([]BondContractDetails, error) {
+	w, err := b.fetch(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(w.bonds) == 0 {
+		return nil, errors.New("contract details request returned no bonds")
+	}
+
+	return w.bonds, nil
+}
+
+// FetchBySecId resolves a secondary identifier. secIdType is one of "ISIN",
+// "CUSIP", "SEDOL" or "RIC"; exchange and currency may be blank.
+func (b *ContractDetailsBroker) FetchBySecId(ctx context.Context, secIdType, secId, exchange, currency string) ([]ContractDetails, error) {
+	c := Contract{
+		SecIdType: secIdType,
+		SecId:     secId,
+		Exchange:  exchange,
+		Currency:  currency,
+	}
+
+	return b.Fetch(ctx, c)
+}
+
+func (b *ContractDetailsBroker) fetch(ctx context.Context, c Contract) (*contractDetailsWaiter, error) {
+	b.mu.Lock()
+	id := b.NextReqId()
+	w := &contractDetailsWaiter{done: make(chan struct{})}
+	b.pending[id] = w
+	b.mu.Unlock()
+
+	r := ContractDetailsRequest{c}
+	r.Send(id, b)
+
+	select {
+	case <-w.done:
+		return w, w.err
+	case <-ctx.Done():
+		b.discarded.Add(id)
+		b.release(id)
+
+		return nil, ctx.Err()
+	}
+}
+
+// fail completes a pending Fetch with err and discards the rest of its
+// responses. Errors for abandoned requests are dropped and errors for other
+// requests logged.
+func (b *ContractDetailsBroker) fail(id int64, err error) {
+	if w := b.release(id); w != nil {
+		b.discarded.Add(id)
+		w.err = err
+		close(w.done)
+		return
+	}
+
+	if b.discarded.Has(id) {
+		return
+	}
+
+	Log.Print("error", err)
+}
+
+func (b *ContractDetailsBroker) waiter(id int64) *contractDetailsWaiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending[id]
+}
+
+func (b *ContractDetailsBroker) release(id int64) *contractDetailsWaiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := b.pending[id]
+
+	if w != nil {
+		delete(b.pending, id)
+		delete(b.Contracts, id)
+	}
+
+	return w
+}
+
+// ReadBondContractDetails decodes a BondContractData message according to its
+// version.
+func (b *ContractDetailsBroker) ReadBondContractDetails(version string) (BondContractDetails, error) {
+	var c BondContractDetails
+
+	ver, err := strconv.ParseInt(version, 10, 64)
+
+	if err != nil {
+		return c, fmt.Errorf("bond contract details version %q: %v", version, err)
+	}
+
+	c.Rid = -1
+
+	if ver >= 3 {
+		err = b.ReadFields(&c.Rid)
+	}
+
+	errs := []error{err}
+
+	errs = append(errs, b.ReadFields(
+		&c.Symbol,
+		&c.SecurityType,
+		&c.Cusip,
+		&c.Coupon,
+		&c.Maturity,
+		&c.IssueDate,
+		&c.Ratings,
+		&c.BondType,
+		&c.CouponType,
+		&c.Convertible,
+		&c.Callable,
+		&c.Puttable,
+		&c.DescAppend,
+		&c.Exchange,
+		&c.Currency,
+		&c.MarketName,
+		&c.TradingClass,
+		&c.ContractId,
+		&c.MinTick,
+		&c.OrderTypes,
+		&c.ValidExchanges,
+	))
+
+	if ver >= 2 {
+		errs = append(errs, b.ReadFields(
+			&c.NextOptionDate,
+			&c.NextOptionType,
+			&c.NextOptionPartial,
+			&c.Notes,
+		))
+	}
+
+	if ver >= 4 {
+		errs = append(errs, b.ReadFields(&c.LongName))
+	}
+
+	if ver >= 6 {
+		errs = append(errs, b.ReadFields(&c.EconValueRule, &c.EconValueMultiplier))
+	}
+
+	if ver >= 5 {
+		errs = append(errs, b.ReadFields(&c.SecIdListCount))
+
+		for i := 0; i < int(c.SecIdListCount); i++ {
+			var tv TagValue
+
+			errs = append(errs, b.ReadFields(&tv.Tag, &tv.Value))
+			c.SecIdList = append(c.SecIdList, tv)
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return c, fmt.Errorf("bond contract details %d: %v", c.Rid, err)
 		}
 	}
+
+	return c, nil
 }
 
-func (b *ContractDetailsBroker) ReadContractDetails(version string) ContractDetails {
+func (b *ContractDetailsBroker) ReadContractDetailsEnd(version string) ContractDetailsEnd {
+	var r ContractDetailsEnd
+
+	r.Rid, _ = b.ReadInt()
+
+	return r
+}
+
+// ReadContractDetails decodes a ContractData message. Fields are read
+// according to the message version; older servers omit the later fields.
+func (b *ContractDetailsBroker) ReadContractDetails(version string) (ContractDetails, error) {
 	var c ContractDetails
+	var multiplier string
+
+	ver, err := strconv.ParseInt(version, 10, 64)
 
-	c.Rid, _ = b.ReadInt()
-	c.Symbol, _ = b.ReadString()
-	c.SecurityType, _ = b.ReadString()
-	c.Expiry, _ = b.ReadString()
-	c.Strike, _ = b.ReadFloat()
-	c.Right, _ = b.ReadString()
-	c.Exchange, _ = b.ReadString()
-	c.Currency, _ = b.ReadString()
-	c.LocalSymbol, _ = b.ReadString()
-	c.MarketName, _ = b.ReadString()
-	c.TradingClass, _ = b.ReadString()
-	c.ContractId, _ = b.ReadInt()
-	c.MinTick, _ = b.ReadInt()
-	c.Multiplier, _ = b.ReadInt()
-	c.OrderTypes, _ = b.ReadString()
-	c.ValidExchanges, _ = b.ReadString()
-	c.PriceMagnifier, _ = b.ReadInt()
-	c.UnderlyingContractId, _ = b.ReadInt()
-	c.LongName, _ = b.ReadString()
-	c.PrimaryExchange, _ = b.ReadString()
-	c.ContractMonth, _ = b.ReadString()
-	c.Industry, _ = b.ReadString()
-	c.Category, _ = b.ReadString()
-	c.SubCategory, _ = b.ReadString()
-	c.TimeZoneId, _ = b.ReadString()
-	c.TradingHours, _ = b.ReadString()
-	c.LiquidHours, _ = b.ReadString()
-	c.EconValueRule, _ = b.ReadString()
-	c.EconValueMultiplier, _ = b.ReadFloat()
-	c.SecIdListCount, _ = b.ReadInt()
-
-	for i := 0; i < int(c.SecIdListCount); i++ {
-		var t, v string
-
-		t, _ = b.ReadString()
-		v, _ = b.ReadString()
-		tv := TagValue{t, v}
-		c.SecIdList = append(c.SecIdList, tv)
+	if err != nil {
+		return c, fmt.Errorf("contract details version %q: %v", version, err)
 	}
 
-	return c
+	c.Rid = -1
+
+	if ver >= 3 {
+		err = b.ReadFields(&c.Rid)
+	}
+
+	errs := []error{err}
+
+	errs = append(errs, b.ReadFields(
+		&c.Symbol,
+		&c.SecurityType,
+		&c.Expiry,
+		&c.Strike,
+		&c.Right,
+		&c.Exchange,
+		&c.Currency,
+		&c.LocalSymbol,
+		&c.MarketName,
+		&c.TradingClass,
+		&c.ContractId,
+		&c.MinTick,
+		&multiplier,
+		&c.OrderTypes,
+		&c.ValidExchanges,
+	))
+
+	if multiplier != "" {
+		c.Multiplier, err = strconv.ParseFloat(multiplier, 64)
+		errs = append(errs, err)
+	}
+
+	if ver >= 2 {
+		errs = append(errs, b.ReadFields(&c.PriceMagnifier))
+	}
+
+	if ver >= 4 {
+		errs = append(errs, b.ReadFields(&c.UnderlyingContractId))
+	}
+
+	if ver >= 5 {
+		errs = append(errs, b.ReadFields(&c.LongName, &c.PrimaryExchange))
+	}
+
+	if ver >= 6 {
+		errs = append(errs, b.ReadFields(
+			&c.ContractMonth,
+			&c.Industry,
+			&c.Category,
+			&c.SubCategory,
+			&c.TimeZoneId,
+			&c.TradingHours,
+			&c.LiquidHours,
+		))
+	}
+
+	if ver >= 8 {
+		errs = append(errs, b.ReadFields(&c.EconValueRule, &c.EconValueMultiplier))
+	}
+
+	if ver >= 7 {
+		errs = append(errs, b.ReadFields(&c.SecIdListCount))
+
+		for i := 0; i < int(c.SecIdListCount); i++ {
+			var tv TagValue
+
+			errs = append(errs, b.ReadFields(&tv.Tag, &tv.Value))
+			c.SecIdList = append(c.SecIdList, tv)
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return c, fmt.Errorf("contract details %d: %v", c.Rid, err)
+		}
+	}
+
+	return c, nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -192,8 +612,8 @@ func (b *ContractDetailsBroker) ContractDetailsToJSON(d *ContractDetails) ([]byt
 		MarketName           string
 		TradingClass         string
 		ContractId           string
-		MinTick              int64
-		Multiplier           int64
+		MinTick              float64
+		Multiplier           float64
 		OrderTypes           string
 		ValidExchanges       string
 		PriceMagnifier       int64
@@ -209,8 +629,8 @@ func (b *ContractDetailsBroker) ContractDetailsToJSON(d *ContractDetails) ([]byt
 		LiquidHours          string
 		EconValueRule        string
 		EconValueMultiplier  float64
-		//  	SecIdListCount       int64
-		//  	SecIdList            []TagValue
+		SecIdListCount       int64
+		SecIdList            []TagValue
 	}{
 		Rid:                  d.Rid,
 		Time:                 strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
@@ -242,8 +662,8 @@ func (b *ContractDetailsBroker) ContractDetailsToJSON(d *ContractDetails) ([]byt
 		LiquidHours:          d.LiquidHours,
 		EconValueRule:        d.EconValueRule,
 		EconValueMultiplier:  d.EconValueMultiplier,
-		//  	SecIdListCount:       d.SecIdListCount,
-		//  	SecIdList:            d.SecIdList,
+		SecIdListCount:       d.SecIdListCount,
+		SecIdList:            d.SecIdList,
 	})
 
 	return bytes.Replace(r, []byte("\\u0026"), []byte("&"), -1), err
@@ -251,7 +671,7 @@ func (b *ContractDetailsBroker) ContractDetailsToJSON(d *ContractDetails) ([]byt
 
 func (b *ContractDetailsBroker) ContractDetailsToCSV(d *ContractDetails) string {
 	return fmt.Sprintf(
-		"%d,%s,%s,%s,%s,%.2f,%s,%s,%s,%s,%s,%s,%d,%d,%d,%s,%s,%d,%d,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%.2f",
+		"%d,%s,%s,%s,%s,%.2f,%s,%s,%s,%s,%s,%s,%d,%g,%g,%s,%s,%d,%d,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%.2f,%d,%s",
 		d.Rid,
 		strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
 		d.Symbol,
@@ -282,7 +702,128 @@ func (b *ContractDetailsBroker) ContractDetailsToCSV(d *ContractDetails) string
 		d.LiquidHours,
 		d.EconValueRule,
 		d.EconValueMultiplier,
-		//    d.SecIdListCount,
-		//    d.SecIdList,
+		d.SecIdListCount,
+		SecIdListToString(d.SecIdList),
 	)
 }
+
+func (b *ContractDetailsBroker) BondContractDetailsToJSON(d *BondContractDetails) ([]byte, error) {
+	r, err := json.Marshal(struct {
+		Rid                 int64
+		Time                string
+		Symbol              string
+		SecurityType        string
+		Cusip               string
+		Coupon              float64
+		Maturity            string
+		IssueDate           string
+		Ratings             string
+		BondType            string
+		CouponType          string
+		Convertible         bool
+		Callable            bool
+		Puttable            bool
+		DescAppend          string
+		Exchange            string
+		Currency            string
+		MarketName          string
+		TradingClass        string
+		ContractId          string
+		MinTick             float64
+		OrderTypes          string
+		ValidExchanges      string
+		NextOptionDate      string
+		NextOptionType      string
+		NextOptionPartial   bool
+		Notes               string
+		LongName            string
+		EconValueRule       string
+		EconValueMultiplier float64
+		SecIdListCount      int64
+		SecIdList           []TagValue
+	}{
+		Rid:                 d.Rid,
+		Time:                strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		Symbol:              d.Symbol,
+		SecurityType:        d.SecurityType,
+		Cusip:               d.Cusip,
+		Coupon:              d.Coupon,
+		Maturity:            d.Maturity,
+		IssueDate:           d.IssueDate,
+		Ratings:             d.Ratings,
+		BondType:            d.BondType,
+		CouponType:          d.CouponType,
+		Convertible:         d.Convertible,
+		Callable:            d.Callable,
+		Puttable:            d.Puttable,
+		DescAppend:          d.DescAppend,
+		Exchange:            d.Exchange,
+		Currency:            d.Currency,
+		MarketName:          d.MarketName,
+		TradingClass:        d.TradingClass,
+		ContractId:          strconv.FormatInt(d.ContractId, 10),
+		MinTick:             d.MinTick,
+		OrderTypes:          d.OrderTypes,
+		ValidExchanges:      d.ValidExchanges,
+		NextOptionDate:      d.NextOptionDate,
+		NextOptionType:      d.NextOptionType,
+		NextOptionPartial:   d.NextOptionPartial,
+		Notes:               d.Notes,
+		LongName:            d.LongName,
+		EconValueRule:       d.EconValueRule,
+		EconValueMultiplier: d.EconValueMultiplier,
+		SecIdListCount:      d.SecIdListCount,
+		SecIdList:           d.SecIdList,
+	})
+
+	return bytes.Replace(r, []byte("\\u0026"), []byte("&"), -1), err
+}
+
+func (b *ContractDetailsBroker) BondContractDetailsToCSV(d *BondContractDetails) string {
+	return fmt.Sprintf(
+		"%d,%s,%s,%s,%s,%.4f,%s,%s,%s,%s,%s,%t,%t,%t,%s,%s,%s,%s,%s,%d,%g,%s,%s,%s,%s,%t,%s,%s,%s,%.2f,%d,%s",
+		d.Rid,
+		strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		d.Symbol,
+		d.SecurityType,
+		d.Cusip,
+		d.Coupon,
+		d.Maturity,
+		d.IssueDate,
+		d.Ratings,
+		d.BondType,
+		d.CouponType,
+		d.Convertible,
+		d.Callable,
+		d.Puttable,
+		d.DescAppend,
+		d.Exchange,
+		d.Currency,
+		d.MarketName,
+		d.TradingClass,
+		d.ContractId,
+		d.MinTick,
+		d.OrderTypes,
+		d.ValidExchanges,
+		d.NextOptionDate,
+		d.NextOptionType,
+		d.NextOptionPartial,
+		d.Notes,
+		d.LongName,
+		d.EconValueRule,
+		d.EconValueMultiplier,
+		d.SecIdListCount,
+		SecIdListToString(d.SecIdList),
+	)
+}
+
+// SecIdListToString formats a SecIdList for CSV output as "ISIN=...;CUSIP=...".
+func SecIdListToString(l []TagValue) string {
+	f := make([]string, len(l))
+
+	for i, tv := range l {
+		f[i] = tv.Tag + "=" + tv.Value
+	}
+
+	return strings.Join(f, ";")
+}
@@ -0,0 +1,289 @@
+package ib
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// CACHE
+////////////////////////////////////////////////////////////////////////////////
+
+// ContractDetailsCache sits in front of a ContractDetailsBroker and keeps the
+// details it has seen by contract id and by normalized contract spec. Entries
+// older than TTL are treated as missing. The cache is persisted as JSON at Path.
+type ContractDetailsCache struct {
+	Path   string
+	TTL    time.Duration
+	Pacing time.Duration // minimum delay between requests sent by Warm
+	ById   map[int64]ContractDetailsCacheEntry
+	BySpec map[string]ContractDetailsSpecEntry
+	mu     sync.Mutex
+}
+
+type ContractDetailsCacheEntry struct {
+	Details ContractDetails
+	Updated time.Time
+}
+
+type ContractDetailsSpecEntry struct {
+	ContractIds []int64
+	Updated     time.Time
+}
+
+func NewContractDetailsCache(path string, ttl time.Duration) *ContractDetailsCache {
+	return &ContractDetailsCache{
+		Path:   path,
+		TTL:    ttl,
+		Pacing: time.Second,
+		ById:   make(map[int64]ContractDetailsCacheEntry),
+		BySpec: make(map[string]ContractDetailsSpecEntry),
+	}
+}
+
+// ContractSpec returns the normalized spec used to key contracts that do not
+// carry a contract id.
+func ContractSpec(c Contract) string {
+	strike := ""
+
+	if c.Strike != 0 {
+		strike = strconv.FormatFloat(c.Strike, 'f', -1, 64)
+	}
+
+	f := []string{
+		c.Symbol,
+		c.SecurityType,
+		c.Expiry,
+		strike,
+		c.Right,
+		normalizeMultiplier(c.Multiplier),
+		c.Exchange,
+		c.Currency,
+		c.LocalSymbol,
+		c.TradingClass,
+		c.SecIdType,
+		c.SecId,
+	}
+
+	for i := range f {
+		f[i] = strings.ToUpper(strings.TrimSpace(f[i]))
+	}
+
+	return strings.Join(f, "|")
+}
+
+func (c *ContractDetailsCache) fresh(t time.Time) bool {
+	return c.TTL <= 0 || time.Since(t) < c.TTL
+}
+
+// Get returns the cached details for a contract, looked up by contract id when
+// it is set and by spec otherwise.
+func (c *ContractDetailsCache) Get(k Contract) ([]ContractDetails, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if k.ContractId != 0 {
+		e, ok := c.ById[k.ContractId]
+
+		if !ok || !c.fresh(e.Updated) {
+			return nil, false
+		}
+
+		return []ContractDetails{e.Details}, true
+	}
+
+	s, ok := c.BySpec[ContractSpec(k)]
+
+	if !ok || !c.fresh(s.Updated) {
+		return nil, false
+	}
+
+	r := make([]ContractDetails, 0, len(s.ContractIds))
+
+	for _, id := range s.ContractIds {
+		e, ok := c.ById[id]
+
+		if !ok || !c.fresh(e.Updated) {
+			return nil, false
+		}
+
+		r = append(r, e.Details)
+	}
+
+	return r, true
+}
+
+// Put stores the details returned for a contract request.
+func (c *ContractDetailsCache) Put(k Contract, d []ContractDetails) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	ids := make([]int64, 0, len(d))
+
+	for _, v := range d {
+		v.Rid = 0
+		c.ById[v.ContractId] = ContractDetailsCacheEntry{v, now}
+		ids = append(ids, v.ContractId)
+	}
+
+	if k.ContractId == 0 {
+		c.BySpec[ContractSpec(k)] = ContractDetailsSpecEntry{ids, now}
+	}
+}
+
+// FindSecId returns the cached contracts whose SecIdList carries the given
+// secondary identifier.
+func (c *ContractDetailsCache) FindSecId(secIdType, secId string) []ContractDetails {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var r []ContractDetails
+
+	for _, e := range c.ById {
+		if !c.fresh(e.Updated) {
+			continue
+		}
+
+		if v, ok := e.Details.SecId(secIdType); ok && v == secId {
+			r = append(r, e.Details)
+		}
+	}
+
+	return r
+}
+
+// Request returns the cached details for k or fetches them from the gateway
+// through b and caches the result.
+func (c *ContractDetailsCache) Request(ctx context.Context, b *ContractDetailsBroker, k Contract) ([]ContractDetails, error) {
+	if d, ok := c.Get(k); ok {
+		return d, nil
+	}
+
+	d, err := b.Fetch(ctx, k)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.Put(k, d)
+
+	return d, nil
+}
+
+// Warm fetches every contract that is missing or stale, waiting at least
+// Pacing between consecutive gateway requests, and saves the cache when done.
+func (c *ContractDetailsCache) Warm(ctx context.Context, b *ContractDetailsBroker, contracts []Contract) error {
+	var last time.Time
+
+	for _, k := range contracts {
+		if _, ok := c.Get(k); ok {
+			continue
+		}
+
+		if wait := c.Pacing - time.Since(last); !last.IsZero() && wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		last = time.Now()
+		d, err := b.Fetch(ctx, k)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			Log.Print("cache", err)
+			continue
+		}
+
+		c.Put(k, d)
+	}
+
+	if c.Path == "" {
+		return nil
+	}
+
+	return c.Save()
+}
+
+// Load reads the cache file at Path. A missing file is not an error.
+func (c *ContractDetailsCache) Load() error {
+	buf, err := os.ReadFile(c.Path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var f struct {
+		ById   map[int64]ContractDetailsCacheEntry
+		BySpec map[string]ContractDetailsSpecEntry
+	}
+
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range f.ById {
+		if c.fresh(v.Updated) {
+			c.ById[k] = v
+		}
+	}
+
+	for k, v := range f.BySpec {
+		if c.fresh(v.Updated) {
+			c.BySpec[k] = v
+		}
+	}
+
+	return nil
+}
+
+// Save writes the cache to Path, replacing the previous file atomically.
+func (c *ContractDetailsCache) Save() error {
+	c.mu.Lock()
+	buf, err := json.Marshal(struct {
+		ById   map[int64]ContractDetailsCacheEntry
+		BySpec map[string]ContractDetailsSpecEntry
+	}{c.ById, c.BySpec})
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), filepath.Base(c.Path)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.Path)
+}
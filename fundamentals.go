@@ -0,0 +1,517 @@
+package ib
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// REQUESTS
+////////////////////////////////////////////////////////////////////////////////
+
+type FundamentalReportType string
+
+const (
+	ReportSnapshot       FundamentalReportType = "ReportSnapshot"       // company overview
+	ReportsFinSummary    FundamentalReportType = "ReportsFinSummary"    // financial summary
+	ReportRatios         FundamentalReportType = "ReportRatios"         // financial ratios
+	ReportsFinStatements FundamentalReportType = "ReportsFinStatements" // financial statements
+	RESC                 FundamentalReportType = "RESC"                 // analyst estimates
+	CalendarReport       FundamentalReportType = "CalendarReport"       // company calendar
+)
+
+type FundamentalDataRequest struct {
+	Rid        int64
+	Contract   Contract
+	ReportType FundamentalReportType
+}
+
+func init() {
+	REQUEST_CODE["FundamentalData"] = 52
+	REQUEST_VERSION["FundamentalData"] = 2
+}
+
+// Send registers the request under its id and sends it. Requests may be sent
+// from several goroutines.
+func (r *FundamentalDataRequest) Send(b *FundamentalsBroker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Contracts[r.Rid] = r.Contract
+	b.WriteInt(REQUEST_CODE["FundamentalData"])
+	b.WriteInt(REQUEST_VERSION["FundamentalData"])
+	b.WriteInt(r.Rid)
+	b.WriteInt(r.Contract.ContractId)
+	b.WriteString(r.Contract.Symbol)
+	b.WriteString(r.Contract.SecurityType)
+	b.WriteString(r.Contract.Exchange)
+	b.WriteString(r.Contract.PrimaryExchange)
+	b.WriteString(r.Contract.Currency)
+	b.WriteString(r.Contract.LocalSymbol)
+	b.WriteString(string(r.ReportType))
+
+	b.Broker.SendRequest()
+}
+
+type CancelFundamentalDataRequest struct {
+	Rid int64
+}
+
+func init() {
+	REQUEST_CODE["CancelFundamentalData"] = 53
+	REQUEST_VERSION["CancelFundamentalData"] = 1
+}
+
+func (r *CancelFundamentalDataRequest) Send(b *FundamentalsBroker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.WriteInt(REQUEST_CODE["CancelFundamentalData"])
+	b.WriteInt(REQUEST_VERSION["CancelFundamentalData"])
+	b.WriteInt(r.Rid)
+
+	b.Broker.SendRequest()
+
+	delete(b.Contracts, r.Rid)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RESPONSES
+////////////////////////////////////////////////////////////////////////////////
+
+type FundamentalData struct {
+	Rid  int64
+	Data string // the report as XML
+}
+
+func init() {
+	RESPONSE_CODE["FundamentalData"] = "51"
+}
+
+// FundamentalReport is a fundamental data report as raw XML together with its
+// decoded form. Only the field matching Type is set, and ReportRatios and
+// CalendarReport are only available as XML.
+type FundamentalReport struct {
+	Rid        int64
+	Contract   Contract
+	Type       FundamentalReportType
+	XML        string
+	Snapshot   *CompanySnapshot
+	Summary    *FinancialSummary
+	Statements *FinancialStatements
+	Estimates  *AnalystEstimates
+}
+
+// CompanySnapshot is a decoded ReportSnapshot.
+type CompanySnapshot struct {
+	CompanyIds []ReportValue    `xml:"CoIDs>CoID"`
+	Employees  int64            `xml:"CoGeneralInfo>Employees"`
+	Texts      []ReportValue    `xml:"TextInfo>Text"`
+	Ratios     []SnapshotRatio  `xml:"Ratios>Group>Ratio"`
+	Forecasts  []SnapshotRatio  `xml:"ForecastData>Ratio"`
+	Industries []IndustryCode   `xml:"peerInfo>IndustryInfo>Industry"`
+	Officers   []CompanyOfficer `xml:"officers>officer"`
+}
+
+type ReportValue struct {
+	Type  string `xml:"Type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type SnapshotRatio struct {
+	FieldName string `xml:"FieldName,attr"`
+	Type      string `xml:"Type,attr"`
+	Value     string `xml:",chardata"`
+	Forecast  string `xml:"Value"` // forecast ratios nest their value
+}
+
+type IndustryCode struct {
+	Type        string `xml:"type,attr"`
+	Code        string `xml:"code,attr"`
+	Description string `xml:",chardata"`
+}
+
+type CompanyOfficer struct {
+	FirstName string `xml:"firstName"`
+	LastName  string `xml:"lastName"`
+	Title     string `xml:"title"`
+}
+
+// Value returns the CoID or Text of the given type, e.g. "CompanyName" or
+// "Business Summary".
+func (s *CompanySnapshot) Value(t string) string {
+	for _, v := range s.CompanyIds {
+		if v.Type == t {
+			return v.Value
+		}
+	}
+
+	for _, v := range s.Texts {
+		if v.Type == t {
+			return v.Value
+		}
+	}
+
+	return ""
+}
+
+func (s *CompanySnapshot) Ratio(field string) (string, bool) {
+	for _, r := range s.Ratios {
+		if r.FieldName == field {
+			return r.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// FinancialSummary is a decoded ReportsFinSummary.
+type FinancialSummary struct {
+	EPS               []SummaryValue `xml:"EPSs>EPS"`
+	DividendsPerShare []SummaryValue `xml:"DividendPerShares>DividendPerShare"`
+	TotalRevenues     []SummaryValue `xml:"TotalRevenues>TotalRevenue"`
+	Dividends         []SummaryValue `xml:"Dividends>Dividend"`
+}
+
+type SummaryValue struct {
+	AsOfDate   string  `xml:"asofDate,attr"`
+	ReportType string  `xml:"reportType,attr"` // "A" actual, "P" preliminary, "R" restated, "TTM"
+	Period     string  `xml:"period,attr"`     // "3M", "12M" or "TTM"
+	Value      float64 `xml:",chardata"`
+}
+
+// FinancialStatements is a decoded ReportsFinStatements. Line items are keyed
+// by COA code, which Items labels.
+type FinancialStatements struct {
+	Items   []COAItem      `xml:"FinancialStatements>COAMap>mapItem"`
+	Annual  []FiscalPeriod `xml:"FinancialStatements>AnnualPeriods>FiscalPeriod"`
+	Interim []FiscalPeriod `xml:"FinancialStatements>InterimPeriods>FiscalPeriod"`
+}
+
+type COAItem struct {
+	Code          string `xml:"coaItem,attr"`
+	StatementType string `xml:"statementType,attr"` // "INC", "BAL" or "CAS"
+	LineId        string `xml:"lineID,attr"`
+	Precision     string `xml:"precision,attr"`
+	Label         string `xml:",chardata"`
+}
+
+type FiscalPeriod struct {
+	Type         string      `xml:"Type,attr"`
+	EndDate      string      `xml:"EndDate,attr"`
+	FiscalYear   string      `xml:"FiscalYear,attr"`
+	FiscalPeriod string      `xml:"FiscalPeriodNumber,attr"`
+	Statements   []Statement `xml:"Statement"`
+}
+
+type Statement struct {
+	Type          string     `xml:"Type,attr"` // "INC", "BAL" or "CAS"
+	StatementDate string     `xml:"FPHeader>StatementDate"`
+	PeriodLength  int64      `xml:"FPHeader>PeriodLength"`
+	PeriodType    string     `xml:"FPHeader>periodType"`
+	Items         []LineItem `xml:"lineItem"`
+}
+
+type LineItem struct {
+	Code  string  `xml:"coaCode,attr"`
+	Value float64 `xml:",chardata"`
+}
+
+// Label returns the description of a COA code such as "SREV".
+func (f *FinancialStatements) Label(code string) string {
+	for _, i := range f.Items {
+		if i.Code == code {
+			return i.Label
+		}
+	}
+
+	return code
+}
+
+func (p *FiscalPeriod) Statement(t string) (*Statement, bool) {
+	for i := range p.Statements {
+		if p.Statements[i].Type == t {
+			return &p.Statements[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Value returns the line item with the given COA code.
+func (s *Statement) Value(code string) (float64, bool) {
+	for _, i := range s.Items {
+		if i.Code == code {
+			return i.Value, true
+		}
+	}
+
+	return 0, false
+}
+
+// AnalystEstimates is a decoded RESC report.
+type AnalystEstimates struct {
+	Annual    []FYEstimate `xml:"ConsEstimates>FYEstimates>FYEstimate"`
+	NonPeriod []NPEstimate `xml:"ConsEstimates>NPEstimates>NPEstimate"`
+}
+
+type FYEstimate struct {
+	Type    string           `xml:"type,attr"` // e.g. "EPS", "REVENUE"
+	Unit    string           `xml:"unit,attr"`
+	Periods []EstimatePeriod `xml:"FYPeriod"`
+}
+
+type EstimatePeriod struct {
+	PeriodType string         `xml:"periodType,attr"` // "A" annual, "Q" quarterly
+	FiscalYear string         `xml:"fYear,attr"`
+	EndMonth   string         `xml:"endMonth,attr"`
+	EndCalYear string         `xml:"endCalYear,attr"`
+	Estimates  []ConsEstimate `xml:"ConsEstimate"`
+}
+
+type NPEstimate struct {
+	Type      string         `xml:"type,attr"` // e.g. "TARGETPRICE", "REC"
+	Unit      string         `xml:"unit,attr"`
+	Estimates []ConsEstimate `xml:"ConsEstimate"`
+}
+
+type ConsEstimate struct {
+	Type   string      `xml:"type,attr"` // e.g. "Mean", "High", "Low", "NumOfEst"
+	Values []ConsValue `xml:"ConsValue"`
+}
+
+type ConsValue struct {
+	DateType string  `xml:"dateType,attr"` // "CURR", "1WA", "1MA", ...
+	Value    float64 `xml:",chardata"`
+}
+
+// Current returns the current value of a consensus statistic such as "Mean".
+func (p *EstimatePeriod) Current(stat string) (float64, bool) {
+	return currentEstimate(p.Estimates, stat)
+}
+
+func (e *NPEstimate) Current(stat string) (float64, bool) {
+	return currentEstimate(e.Estimates, stat)
+}
+
+func currentEstimate(l []ConsEstimate, stat string) (float64, bool) {
+	for _, e := range l {
+		if e.Type != stat {
+			continue
+		}
+
+		for _, v := range e.Values {
+			if v.DateType == "CURR" {
+				return v.Value, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Estimate returns the estimates of type t (e.g. "EPS") for a fiscal year and
+// period type.
+func (a *AnalystEstimates) Estimate(t, fiscalYear, periodType string) (*EstimatePeriod, bool) {
+	for i := range a.Annual {
+		if a.Annual[i].Type != t {
+			continue
+		}
+
+		for j := range a.Annual[i].Periods {
+			p := &a.Annual[i].Periods[j]
+
+			if p.FiscalYear == fiscalYear && p.PeriodType == periodType {
+				return p, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// ParseFundamentalReport decodes the XML of a report of type t. Reports
+// without a decoded form are returned with XML only.
+func ParseFundamentalReport(t FundamentalReportType, data string) (FundamentalReport, error) {
+	r := FundamentalReport{Type: t, XML: data}
+
+	var v interface{}
+
+	switch t {
+	case ReportSnapshot:
+		r.Snapshot = &CompanySnapshot{}
+		v = r.Snapshot
+	case ReportsFinSummary:
+		r.Summary = &FinancialSummary{}
+		v = r.Summary
+	case ReportsFinStatements:
+		r.Statements = &FinancialStatements{}
+		v = r.Statements
+	case RESC:
+		r.Estimates = &AnalystEstimates{}
+		v = r.Estimates
+	default:
+		return r, nil
+	}
+
+	if err := xml.Unmarshal([]byte(data), v); err != nil {
+		return r, fmt.Errorf("%s report: %v", t, err)
+	}
+
+	return r, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BROKER
+////////////////////////////////////////////////////////////////////////////////
+
+type FundamentalsBroker struct {
+	Broker
+	Contracts           map[int64]Contract
+	FundamentalDataChan chan FundamentalData
+	pending             map[int64]*fundamentalsWaiter
+	discarded           *discardSet
+	mu                  *sync.Mutex
+}
+
+type fundamentalsWaiter struct {
+	data string
+	err  error
+	done chan struct{}
+}
+
+func NewFundamentalsBroker() FundamentalsBroker {
+	b := FundamentalsBroker{
+		Broker{},
+		make(map[int64]Contract),
+		make(chan FundamentalData),
+		make(map[int64]*fundamentalsWaiter),
+		newDiscardSet(),
+		&sync.Mutex{},
+	}
+
+	return b
+}
+
+func (b *FundamentalsBroker) Listen() {
+	for {
+		s, err := b.ReadString()
+
+		if err != nil {
+			continue
+		}
+
+		switch s {
+		case RESPONSE_CODE["FundamentalData"]:
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			r := b.ReadFundamentalData(version)
+
+			if w := b.release(r.Rid); w != nil {
+				w.data = r.Data
+				close(w.done)
+				continue
+			}
+
+			if b.discarded.Has(r.Rid) {
+				continue
+			}
+
+			b.FundamentalDataChan <- r
+		case RESPONSE_CODE["ErrMsg"]:
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			id, code, msg := b.ReadErrMsg(version)
+
+			if IsWarningCode(code) {
+				continue
+			}
+
+			err = fmt.Errorf("fundamental data request %d: error %d: %s", id, code, msg)
+
+			if w := b.release(id); w != nil {
+				w.err = err
+				close(w.done)
+				continue
+			}
+
+			if b.discarded.Has(id) {
+				continue
+			}
+
+			Log.Print("error", err)
+		}
+	}
+}
+
+func (b *FundamentalsBroker) ReadFundamentalData(version string) FundamentalData {
+	var r FundamentalData
+
+	r.Rid, _ = b.ReadInt()
+	r.Data, _ = b.ReadString()
+
+	return r
+}
+
+// Fetch requests a fundamental data report for c and blocks until it has
+// been received and decoded or ctx is done. Listen must be running.
+func (b *FundamentalsBroker) Fetch(ctx context.Context, c Contract, t FundamentalReportType) (FundamentalReport, error) {
+	b.mu.Lock()
+	id := b.NextReqId()
+	w := &fundamentalsWaiter{done: make(chan struct{})}
+	b.pending[id] = w
+	b.mu.Unlock()
+
+	req := FundamentalDataRequest{id, c, t}
+	req.Send(b)
+
+	select {
+	case <-w.done:
+		b.mu.Lock()
+		delete(b.Contracts, id)
+		b.mu.Unlock()
+
+		if w.err != nil {
+			return FundamentalReport{Rid: id, Contract: c, Type: t}, w.err
+		}
+
+		r, err := ParseFundamentalReport(t, w.data)
+		r.Rid = id
+		r.Contract = c
+
+		return r, err
+	case <-ctx.Done():
+		b.discarded.Add(id)
+		b.release(id)
+
+		cancel := CancelFundamentalDataRequest{id}
+		cancel.Send(b)
+
+		return FundamentalReport{Rid: id, Contract: c, Type: t}, ctx.Err()
+	}
+}
+
+func (b *FundamentalsBroker) release(id int64) *fundamentalsWaiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := b.pending[id]
+	delete(b.pending, id)
+
+	return w
+}
+
+// Fundamentals fetches a fundamental data report for the contract.
+func (d *ContractDetails) Fundamentals(ctx context.Context, b *FundamentalsBroker, t FundamentalReportType) (FundamentalReport, error) {
+	return b.Fetch(ctx, d.Contract(), t)
+}
@@ -0,0 +1,317 @@
+package ib
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// CONTRACT MONTHS
+////////////////////////////////////////////////////////////////////////////////
+
+// FuturesMonths lists the contracts available for a futures root, e.g.
+// Contract{Symbol: "ES", Exchange: "GLOBEX", Currency: "USD"}, sorted by
+// expiry.
+func (b *ContractDetailsBroker) FuturesMonths(ctx context.Context, root Contract) ([]ContractDetails, error) {
+	c := root
+	c.ContractId = 0
+	c.SecurityType = "FUT"
+	c.Expiry = ""
+	c.LocalSymbol = ""
+
+	d, err := b.Fetch(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	SortByExpiry(d)
+
+	return d, nil
+}
+
+func SortByExpiry(d []ContractDetails) {
+	sort.SliceStable(d, func(i, j int) bool {
+		return futuresExpiry(&d[i]).Before(futuresExpiry(&d[j]))
+	})
+}
+
+func futuresExpiry(d *ContractDetails) time.Time {
+	if t := parseExpiry(d.Expiry); !t.IsZero() {
+		return t
+	}
+
+	return parseExpiry(d.ContractMonth)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ROLL RULES
+////////////////////////////////////////////////////////////////////////////////
+
+// A RollRule decides whether a position in front should be rolled into next
+// at t.
+type RollRule interface {
+	Roll(front, next *ContractDetails, t time.Time) bool
+}
+
+// DaysBeforeExpiry rolls Days calendar days before the front contract expires.
+type DaysBeforeExpiry struct {
+	Days int
+}
+
+func (r DaysBeforeExpiry) Roll(front, next *ContractDetails, t time.Time) bool {
+	e := futuresExpiry(front)
+
+	if e.IsZero() {
+		return false
+	}
+
+	return !t.Before(e.AddDate(0, 0, -r.Days))
+}
+
+// ActivityCrossover rolls once the next contract trades more than the front,
+// as measured by Activity, which typically returns volume or open interest.
+type ActivityCrossover struct {
+	Activity func(d *ContractDetails, t time.Time) float64
+}
+
+func (r ActivityCrossover) Roll(front, next *ContractDetails, t time.Time) bool {
+	return r.Activity(next, t) > r.Activity(front, t)
+}
+
+// FixedCalendar rolls on the date listed for the front contract month in
+// Dates or, when it is not listed, on Day of the month MonthsBefore months
+// ahead of the front contract month.
+type FixedCalendar struct {
+	Dates        map[string]time.Time
+	Day          int
+	MonthsBefore int
+}
+
+func (r FixedCalendar) Roll(front, next *ContractDetails, t time.Time) bool {
+	if d, ok := r.Dates[front.ContractMonth]; ok {
+		return !t.Before(d)
+	}
+
+	e := futuresExpiry(front)
+
+	if e.IsZero() || r.Day == 0 {
+		return false
+	}
+
+	d := time.Date(e.Year(), e.Month()-time.Month(r.MonthsBefore), r.Day, 0, 0, 0, 0, time.UTC)
+
+	return !t.Before(d)
+}
+
+// ActiveContract returns the contract to hold at t. Contracts are expected in
+// expiry order and expired contracts are skipped.
+func ActiveContract(d []ContractDetails, rule RollRule, t time.Time) (ContractDetails, bool) {
+	i := 0
+
+	for i < len(d) {
+		if e := futuresExpiry(&d[i]); e.IsZero() || !e.Before(startOfDay(t)) {
+			break
+		}
+		i++
+	}
+
+	if i == len(d) {
+		return ContractDetails{}, false
+	}
+
+	for i+1 < len(d) && rule.Roll(&d[i], &d[i+1], t) {
+		i++
+	}
+
+	return d[i], true
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// CONTINUOUS SERIES
+////////////////////////////////////////////////////////////////////////////////
+
+type RollAdjustment int64
+
+const (
+	Unadjusted RollAdjustment = iota
+	BackAdjusted
+	RatioAdjusted
+)
+
+// FuturesSegment holds the bars received from a HistoricalDataBroker for one
+// contract month.
+type FuturesSegment struct {
+	Contract ContractDetails
+	Bars     []HistoricalDataItem
+}
+
+// SegmentVolume returns an Activity function for ActivityCrossover that reads
+// the bar volume of each contract from segments.
+func SegmentVolume(segments []FuturesSegment, loc *time.Location) func(*ContractDetails, time.Time) float64 {
+	return func(d *ContractDetails, t time.Time) float64 {
+		for _, s := range segments {
+			if s.Contract.ContractId != d.ContractId {
+				continue
+			}
+
+			for _, bar := range s.Bars {
+				if bt, err := ParseHistoricalDate(bar.Date, loc); err == nil && bt.Equal(t) {
+					return float64(bar.Volume)
+				}
+			}
+		}
+
+		return 0
+	}
+}
+
+// ContinuousSeries stitches segments into a single series, rolling from one
+// contract month to the next at the first bar of the front month for which
+// rule reports a roll. Prices before each roll are shifted by the difference
+// (BackAdjusted) or scaled by the ratio (RatioAdjusted) of the closes of the
+// two contracts at the roll so that the series has no gap. Bar dates are
+// parsed in loc.
+func ContinuousSeries(segments []FuturesSegment, rule RollRule, adj RollAdjustment, loc *time.Location) ([]HistoricalDataItem, error) {
+	if len(segments) == 0 {
+		return nil, errors.New("no futures segments")
+	}
+
+	s := make([]FuturesSegment, len(segments))
+	copy(s, segments)
+	sort.SliceStable(s, func(i, j int) bool {
+		return futuresExpiry(&s[i].Contract).Before(futuresExpiry(&s[j].Contract))
+	})
+
+	type bar struct {
+		t time.Time
+		d HistoricalDataItem
+	}
+
+	parse := func(items []HistoricalDataItem) ([]bar, error) {
+		r := make([]bar, 0, len(items))
+
+		for _, d := range items {
+			t, err := ParseHistoricalDate(d.Date, loc)
+
+			if err != nil {
+				return nil, err
+			}
+
+			r = append(r, bar{t, d})
+		}
+
+		sort.SliceStable(r, func(i, j int) bool { return r[i].t.Before(r[j].t) })
+
+		return r, nil
+	}
+
+	var out []bar
+	var from time.Time
+
+	for i := range s {
+		bars, err := parse(s[i].Bars)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var next []bar
+
+		if i+1 < len(s) {
+			if next, err = parse(s[i+1].Bars); err != nil {
+				return nil, err
+			}
+		}
+
+		// find the roll; the last segment is held to the end of its data
+		roll := -1
+
+		for j := range bars {
+			if bars[j].t.Before(from) {
+				continue
+			}
+
+			if i+1 < len(s) && rule.Roll(&s[i].Contract, &s[i+1].Contract, bars[j].t) {
+				roll = j
+				break
+			}
+
+			out = append(out, bars[j])
+		}
+
+		if i+1 == len(s) {
+			break
+		}
+
+		if roll < 0 {
+			if len(bars) == 0 {
+				continue
+			}
+			from = bars[len(bars)-1].t.Add(time.Nanosecond)
+		} else {
+			from = bars[roll].t
+		}
+
+		if adj == Unadjusted || roll < 0 {
+			continue
+		}
+
+		// the front close is the last one before the roll and the next close
+		// is the last one at or before the same time
+		k := roll - 1
+
+		if k < 0 {
+			continue
+		}
+
+		fc := bars[k].d.Close
+		nc := 0.0
+
+		for _, v := range next {
+			if v.t.After(bars[k].t) {
+				break
+			}
+			nc = v.d.Close
+		}
+
+		if nc == 0 || fc == 0 {
+			continue
+		}
+
+		for j := range out {
+			d := &out[j].d
+
+			if adj == BackAdjusted {
+				diff := nc - fc
+				d.Open += diff
+				d.High += diff
+				d.Low += diff
+				d.Close += diff
+				d.WAP += diff
+			} else {
+				ratio := nc / fc
+				d.Open *= ratio
+				d.High *= ratio
+				d.Low *= ratio
+				d.Close *= ratio
+				d.WAP *= ratio
+			}
+		}
+	}
+
+	r := make([]HistoricalDataItem, len(out))
+
+	for i := range out {
+		r[i] = out[i].d
+	}
+
+	return r, nil
+}
@@ -3,6 +3,10 @@ package ib
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -11,12 +15,12 @@ import (
 
 type HistoricalDataRequest struct {
 	Contract Contract
-	End      string
-	Bar      string
-	Dur      string
+	End      time.Time // zero for data up to now
+	Bar      BarSize
+	Dur      Duration
 	Rth      bool `json:",string"`
-	Show     string
-	Datef    int64
+	Show     WhatToShow
+	Datef    int64 // DateFormatString or DateFormatEpoch
 }
 
 func init() {
@@ -24,8 +28,46 @@ func init() {
 	REQUEST_VERSION["HistoricalData"] = 5
 }
 
-func (r *HistoricalDataRequest) Send(id int64, b *HistoricalDataBroker) {
-	b.Contract = r.Contract
+// Validate checks the bar size against the duration and what to show against
+// the security type, as the gateway would.
+func (r *HistoricalDataRequest) Validate() error {
+	if err := CheckBarSize(r.Dur, r.Bar); err != nil {
+		return err
+	}
+
+	if err := CheckWhatToShow(r.Contract.SecurityType, r.Show); err != nil {
+		return err
+	}
+
+	if r.Show == ShowAdjustedLast && !r.End.IsZero() {
+		return fmt.Errorf("%s requires an empty end time", r.Show)
+	}
+
+	if r.Datef != DateFormatString && r.Datef != DateFormatEpoch {
+		return fmt.Errorf("invalid date format %d", r.Datef)
+	}
+
+	return nil
+}
+
+// Send validates the request, registers it under id, so that the bars it
+// returns are attributed to its contract, and sends it. DateFormatString
+// requests are refused until the broker's Location is set. Requests may be
+// sent from several goroutines.
+func (r *HistoricalDataRequest) Send(id int64, b *HistoricalDataBroker) error {
+	if err := r.Validate(); err != nil {
+		return fmt.Errorf("historical data request %d: %v", id, err)
+	}
+
+	if r.Datef == DateFormatString && b.Location == nil {
+		return fmt.Errorf("historical data request %d: date format %d requires the broker's Location", id, r.Datef)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Contracts[id] = r.Contract
+	b.Requests[id] = *r
 	b.WriteInt(REQUEST_CODE["HistoricalData"])
 	b.WriteInt(REQUEST_VERSION["HistoricalData"])
 	b.WriteInt(id)
@@ -42,30 +84,62 @@ func (r *HistoricalDataRequest) Send(id int64, b *HistoricalDataBroker) {
 	b.WriteString(r.Contract.LocalSymbol)
 	b.WriteString(r.Contract.TradingClass)
 	b.WriteInt(0) // include expired
-	b.WriteString(r.End)
-	b.WriteString(r.Bar)
-	b.WriteString(r.Dur)
+	b.WriteString(FormatHistoricalEnd(r.End))
+	b.WriteString(string(r.Bar))
+	b.WriteString(r.Dur.String())
 	b.WriteBool(r.Rth)
-	b.WriteString(r.Show)
+	b.WriteString(string(r.Show))
 	b.WriteInt(r.Datef)
 
+	_, err := b.Broker.SendRequest()
+
+	return err
+}
+
+type CancelHistoricalDataRequest struct {
+	Rid int64
+}
+
+func init() {
+	REQUEST_CODE["CancelHistoricalData"] = 25
+	REQUEST_VERSION["CancelHistoricalData"] = 1
+}
+
+func (r *CancelHistoricalDataRequest) Send(b *HistoricalDataBroker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.WriteInt(REQUEST_CODE["CancelHistoricalData"])
+	b.WriteInt(REQUEST_VERSION["CancelHistoricalData"])
+	b.WriteInt(r.Rid)
+
 	b.Broker.SendRequest()
+
+	delete(b.Contracts, r.Rid)
+	delete(b.Requests, r.Rid)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 // RESPONSES
 ////////////////////////////////////////////////////////////////////////////////
 
+// HistoricalData holds the bars of a request. When the gateway rejects the
+// request only Rid, Contract and Err are set; when a bar date cannot be
+// parsed the bars are kept and Err reports the first failure.
 type HistoricalData struct {
-	Rid   string
-	Start string
-	End   string
-	Count int64
-	Data  []HistoricalDataItem
+	Rid      int64
+	Contract Contract
+	Start    string
+	End      string
+	Count    int64
+	Data     []HistoricalDataItem
+	Err      error
 }
 
 type HistoricalDataItem struct {
+	Rid          int64
 	Date         string
+	Time         time.Time // Date parsed for either date format
 	Symbol       string
 	Exchange     string
 	SecurityType string
@@ -87,18 +161,75 @@ func init() {
 	RESPONSE_CODE["HistoricalData"] = "17"
 }
 
+// ParseHistoricalDate parses a bar date returned with date format 1
+// ("20160328" or "20160328  09:30:00") or date format 2 (epoch seconds).
+// Daily and longer bars are sent as "20160328" with either format. Format 1
+// times are interpreted in loc, which they cannot be parsed without; epoch
+// dates are returned in loc and calendar dates at midnight in loc, or in UTC
+// when loc is nil.
+func ParseHistoricalDate(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if len(s) > 8 && !strings.Contains(s, " ") {
+		sec, err := strconv.ParseInt(s, 10, 64)
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if loc == nil {
+			return time.Unix(sec, 0).UTC(), nil
+		}
+
+		return time.Unix(sec, 0).In(loc), nil
+	}
+
+	if len(s) == 8 {
+		if loc == nil {
+			return time.Parse("20060102", s)
+		}
+
+		return time.ParseInLocation("20060102", s, loc)
+	}
+
+	if loc == nil {
+		return time.Time{}, fmt.Errorf("no time zone to parse date %q in", s)
+	}
+
+	return time.ParseInLocation("20060102 15:04:05", strings.Join(strings.Fields(s), " "), loc)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // BROKER
 ////////////////////////////////////////////////////////////////////////////////
 
+// HistoricalDataBroker keeps the contract and parameters of every request in
+// flight, keyed by request id, until its bars or an error arrive. Errors are
+// delivered on HistoricalDataChan with Err set. The gateway sends
+// DateFormatString dates in its own time zone, which the handshake does not
+// report; set Location, for example with LoadTimeZone, before sending such
+// requests.
 type HistoricalDataBroker struct {
 	Broker
-	Contract           Contract
+	Contracts          map[int64]Contract
+	Requests           map[int64]HistoricalDataRequest
 	HistoricalDataChan chan HistoricalData
+	TickRules          *TickRules
+	Location           *time.Location // the gateway's time zone, required for DateFormatString
+	mu                 *sync.Mutex
 }
 
 func NewHistoricalDataBroker() HistoricalDataBroker {
-	b := HistoricalDataBroker{Broker{}, Contract{}, make(chan HistoricalData)}
+	b := HistoricalDataBroker{
+		Broker{},
+		make(map[int64]Contract),
+		make(map[int64]HistoricalDataRequest),
+		make(chan HistoricalData),
+		NewTickRules(),
+		nil,
+		&sync.Mutex{},
+	}
+
 	return b
 }
 
@@ -110,7 +241,8 @@ func (b *HistoricalDataBroker) Listen() {
 			continue
 		}
 
-		if s == RESPONSE_CODE["HistoricalData"] {
+		switch s {
+		case RESPONSE_CODE["HistoricalData"]:
 			version, err := b.ReadString()
 
 			if err != nil {
@@ -118,30 +250,97 @@ func (b *HistoricalDataBroker) Listen() {
 			}
 
 			r := b.ReadHistoricalData(version)
+			b.release(r.Rid)
 			b.HistoricalDataChan <- r
+		case RESPONSE_CODE["ErrMsg"]:
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			id, code, msg := b.ReadErrMsg(version)
+
+			if IsWarningCode(code) {
+				continue
+			}
+
+			r, ok := b.Request(id)
+
+			if !ok {
+				continue
+			}
+
+			b.release(id)
+			b.HistoricalDataChan <- HistoricalData{
+				Rid:      id,
+				Contract: r.Contract,
+				Err:      fmt.Errorf("historical data request %d: error %d: %s", id, code, msg),
+			}
 		}
 	}
 }
 
+// Request returns the request sent under id while it is in flight.
+func (b *HistoricalDataBroker) Request(id int64) (HistoricalDataRequest, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r, ok := b.Requests[id]
+
+	return r, ok
+}
+
+// Pending returns the number of requests in flight.
+func (b *HistoricalDataBroker) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.Requests)
+}
+
+func (b *HistoricalDataBroker) release(id int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.Contracts, id)
+	delete(b.Requests, id)
+}
+
 func (b *HistoricalDataBroker) ReadHistoricalData(version string) HistoricalData {
 	var r HistoricalData
 
-	r.Rid, _ = b.ReadString()
+	r.Rid, _ = b.ReadInt()
 	r.Start, _ = b.ReadString()
 	r.End, _ = b.ReadString()
 	r.Count, _ = b.ReadInt()
 
+	b.mu.Lock()
+	c := b.Contracts[r.Rid]
+	b.mu.Unlock()
+
+	r.Contract = c
 	r.Data = make([]HistoricalDataItem, r.Count)
 
+	d, err := b.TickRules.Get(&c)
+	scale := err == nil
+
 	for i := range r.Data {
+		r.Data[i].Rid = r.Rid
 		r.Data[i].Date, _ = b.ReadString()
-		r.Data[i].Symbol = b.Contract.Symbol
-		r.Data[i].Exchange = b.Contract.Exchange
-		r.Data[i].SecurityType = b.Contract.SecurityType
-		r.Data[i].Currency = b.Contract.Currency
-		r.Data[i].Right = b.Contract.Right
-		r.Data[i].Strike = b.Contract.Strike
-		r.Data[i].Expiry = b.Contract.Expiry
+		r.Data[i].Time, err = ParseHistoricalDate(r.Data[i].Date, b.Location)
+
+		if err != nil && r.Err == nil {
+			r.Err = fmt.Errorf("historical data request %d: bar %d: %v", r.Rid, i, err)
+		}
+
+		r.Data[i].Symbol = c.Symbol
+		r.Data[i].Exchange = c.Exchange
+		r.Data[i].SecurityType = c.SecurityType
+		r.Data[i].Currency = c.Currency
+		r.Data[i].Right = c.Right
+		r.Data[i].Strike = c.Strike
+		r.Data[i].Expiry = c.Expiry
 		r.Data[i].Open, _ = b.ReadFloat()
 		r.Data[i].High, _ = b.ReadFloat()
 		r.Data[i].Low, _ = b.ReadFloat()
@@ -150,6 +349,14 @@ func (b *HistoricalDataBroker) ReadHistoricalData(version string) HistoricalData
 		r.Data[i].WAP, _ = b.ReadFloat()
 		r.Data[i].HasGaps, _ = b.ReadBool()
 		r.Data[i].BarCount, _ = b.ReadInt()
+
+		if scale {
+			r.Data[i].Open = d.ScalePrice(r.Data[i].Open)
+			r.Data[i].High = d.ScalePrice(r.Data[i].High)
+			r.Data[i].Low = d.ScalePrice(r.Data[i].Low)
+			r.Data[i].Close = d.ScalePrice(r.Data[i].Close)
+			r.Data[i].WAP = d.ScalePrice(r.Data[i].WAP)
+		}
 	}
 
 	return r
@@ -161,6 +368,7 @@ func (b *HistoricalDataBroker) ReadHistoricalData(version string) HistoricalData
 
 func (b *HistoricalDataBroker) HistoricalDataItemToJSON(d *HistoricalDataItem) ([]byte, error) {
 	return json.Marshal(struct {
+		Rid          int64
 		Date         string
 		Symbol       string
 		Exchange     string
@@ -178,14 +386,15 @@ func (b *HistoricalDataBroker) HistoricalDataItemToJSON(d *HistoricalDataItem) (
 		HasGaps      bool
 		BarCount     int64
 	}{
+		Rid:          d.Rid,
 		Date:         d.Date,
-		Symbol:       b.Contract.Symbol,
-		Exchange:     b.Contract.Exchange,
-		SecurityType: b.Contract.SecurityType,
-		Currency:     b.Contract.Currency,
-		Right:        b.Contract.Right,
-		Strike:       b.Contract.Strike,
-		Expiry:       b.Contract.Expiry,
+		Symbol:       d.Symbol,
+		Exchange:     d.Exchange,
+		SecurityType: d.SecurityType,
+		Currency:     d.Currency,
+		Right:        d.Right,
+		Strike:       d.Strike,
+		Expiry:       d.Expiry,
 		Open:         d.Open,
 		High:         d.High,
 		Low:          d.Low,
@@ -201,13 +410,13 @@ func (b *HistoricalDataBroker) HistoricalDataItemToCSV(d *HistoricalDataItem) st
 	return fmt.Sprintf(
 		"%v,%s,%s,%s,%s,%s,%.2f,%s,%.2f,%.2f,%.2f,%.2f,%d,%.2f,%t,%d",
 		d.Date,
-		b.Contract.Symbol,
-		b.Contract.Exchange,
-		b.Contract.SecurityType,
-		b.Contract.Currency,
-		b.Contract.Right,
-		b.Contract.Strike,
-		b.Contract.Expiry,
+		d.Symbol,
+		d.Exchange,
+		d.SecurityType,
+		d.Currency,
+		d.Right,
+		d.Strike,
+		d.Expiry,
 		d.Open,
 		d.High,
 		d.Low,
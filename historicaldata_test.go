@@ -0,0 +1,44 @@
+package ib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoricalDate(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+
+	tests := []struct {
+		name  string
+		datef int64
+		s     string
+		loc   *time.Location
+		want  time.Time
+	}{
+		{"intraday bar", DateFormatString, "20160328  09:30:00", ny, time.Date(2016, 3, 28, 9, 30, 0, 0, ny)},
+		{"daily bar", DateFormatString, "20160328", ny, time.Date(2016, 3, 28, 0, 0, 0, 0, ny)},
+		{"epoch intraday bar", DateFormatEpoch, "1459171800", nil, time.Date(2016, 3, 28, 13, 30, 0, 0, time.UTC)},
+		{"epoch intraday bar in a zone", DateFormatEpoch, "1459171800", ny, time.Date(2016, 3, 28, 9, 30, 0, 0, ny)},
+		{"epoch daily bar", DateFormatEpoch, "20160328", nil, time.Date(2016, 3, 28, 0, 0, 0, 0, time.UTC)},
+		{"epoch daily bar in a zone", DateFormatEpoch, "20160328", ny, time.Date(2016, 3, 28, 0, 0, 0, 0, ny)},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseHistoricalDate(tt.s, tt.loc)
+
+		if err != nil {
+			t.Errorf("%s: ParseHistoricalDate(%q): %v", tt.name, tt.s, err)
+			continue
+		}
+
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: ParseHistoricalDate(%q) = %v, want %v", tt.name, tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"20160328  09:30:00", "2016032x", "14591718xx"} {
+		if _, err := ParseHistoricalDate(s, nil); err == nil {
+			t.Errorf("ParseHistoricalDate(%q, nil): expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package ib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// HISTORICAL DATA PARAMETERS
+////////////////////////////////////////////////////////////////////////////////
+
+type BarSize string
+
+const (
+	Bar1Sec   BarSize = "1 secs"
+	Bar5Secs  BarSize = "5 secs"
+	Bar10Secs BarSize = "10 secs"
+	Bar15Secs BarSize = "15 secs"
+	Bar30Secs BarSize = "30 secs"
+	Bar1Min   BarSize = "1 min"
+	Bar2Mins  BarSize = "2 mins"
+	Bar3Mins  BarSize = "3 mins"
+	Bar5Mins  BarSize = "5 mins"
+	Bar10Mins BarSize = "10 mins"
+	Bar15Mins BarSize = "15 mins"
+	Bar20Mins BarSize = "20 mins"
+	Bar30Mins BarSize = "30 mins"
+	Bar1Hour  BarSize = "1 hour"
+	Bar2Hours BarSize = "2 hours"
+	Bar3Hours BarSize = "3 hours"
+	Bar4Hours BarSize = "4 hours"
+	Bar8Hours BarSize = "8 hours"
+	Bar1Day   BarSize = "1 day"
+	Bar1Week  BarSize = "1 week"
+	Bar1Month BarSize = "1 month"
+)
+
+var BAR_SIZE_DURATION = map[BarSize]time.Duration{
+	Bar1Sec:   time.Second,
+	Bar5Secs:  5 * time.Second,
+	Bar10Secs: 10 * time.Second,
+	Bar15Secs: 15 * time.Second,
+	Bar30Secs: 30 * time.Second,
+	Bar1Min:   time.Minute,
+	Bar2Mins:  2 * time.Minute,
+	Bar3Mins:  3 * time.Minute,
+	Bar5Mins:  5 * time.Minute,
+	Bar10Mins: 10 * time.Minute,
+	Bar15Mins: 15 * time.Minute,
+	Bar20Mins: 20 * time.Minute,
+	Bar30Mins: 30 * time.Minute,
+	Bar1Hour:  time.Hour,
+	Bar2Hours: 2 * time.Hour,
+	Bar3Hours: 3 * time.Hour,
+	Bar4Hours: 4 * time.Hour,
+	Bar8Hours: 8 * time.Hour,
+	Bar1Day:   24 * time.Hour,
+	Bar1Week:  7 * 24 * time.Hour,
+	Bar1Month: 30 * 24 * time.Hour,
+}
+
+// Duration returns the length of a bar; months count as 30 days.
+func (s BarSize) Duration() (time.Duration, bool) {
+	d, ok := BAR_SIZE_DURATION[s]
+	return d, ok
+}
+
+type DurationUnit string
+
+const (
+	Seconds DurationUnit = "S"
+	Days    DurationUnit = "D"
+	Weeks   DurationUnit = "W"
+	Months  DurationUnit = "M"
+	Years   DurationUnit = "Y"
+)
+
+// Duration is the time span of a historical data request, such as "3 D".
+type Duration struct {
+	N    int64
+	Unit DurationUnit
+}
+
+func (d Duration) String() string {
+	return strconv.FormatInt(d.N, 10) + " " + string(d.Unit)
+}
+
+func ParseDuration(s string) (Duration, error) {
+	f := strings.Fields(s)
+
+	if len(f) != 2 {
+		return Duration{}, fmt.Errorf("invalid duration %q", s)
+	}
+
+	n, err := strconv.ParseInt(f[0], 10, 64)
+
+	if err != nil || n <= 0 {
+		return Duration{}, fmt.Errorf("invalid duration %q", s)
+	}
+
+	d := Duration{n, DurationUnit(strings.ToUpper(f[1]))}
+
+	if _, ok := d.Approx(); !ok {
+		return Duration{}, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+// Approx returns the length of the duration in calendar time, with months as
+// 30 days and years as 365 days.
+func (d Duration) Approx() (time.Duration, bool) {
+	day := 24 * time.Hour
+	n := time.Duration(d.N)
+
+	switch d.Unit {
+	case Seconds:
+		return n * time.Second, true
+	case Days:
+		return n * day, true
+	case Weeks:
+		return n * 7 * day, true
+	case Months:
+		return n * 30 * day, true
+	case Years:
+		return n * 365 * day, true
+	default:
+		return 0, false
+	}
+}
+
+type WhatToShow string
+
+const (
+	ShowTrades                  WhatToShow = "TRADES"
+	ShowMidpoint                WhatToShow = "MIDPOINT"
+	ShowBid                     WhatToShow = "BID"
+	ShowAsk                     WhatToShow = "ASK"
+	ShowBidAsk                  WhatToShow = "BID_ASK"
+	ShowAdjustedLast            WhatToShow = "ADJUSTED_LAST"
+	ShowHistoricalVolatility    WhatToShow = "HISTORICAL_VOLATILITY"
+	ShowOptionImpliedVolatility WhatToShow = "OPTION_IMPLIED_VOLATILITY"
+	ShowFeeRate                 WhatToShow = "FEE_RATE"
+	ShowYieldBid                WhatToShow = "YIELD_BID"
+	ShowYieldAsk                WhatToShow = "YIELD_ASK"
+	ShowYieldBidAsk             WhatToShow = "YIELD_BID_ASK"
+	ShowYieldLast               WhatToShow = "YIELD_LAST"
+	ShowSchedule                WhatToShow = "SCHEDULE"
+)
+
+var quoteShows = []WhatToShow{ShowMidpoint, ShowBid, ShowAsk, ShowBidAsk, ShowSchedule}
+
+// WHAT_TO_SHOW lists the data types the gateway offers per security type.
+// Security types that are not listed are not checked.
+var WHAT_TO_SHOW = map[string][]WhatToShow{
+	"STK":     append([]WhatToShow{ShowTrades, ShowAdjustedLast, ShowHistoricalVolatility, ShowOptionImpliedVolatility, ShowFeeRate}, quoteShows...),
+	"IND":     {ShowTrades, ShowHistoricalVolatility, ShowOptionImpliedVolatility, ShowSchedule},
+	"OPT":     append([]WhatToShow{ShowTrades}, quoteShows...),
+	"FOP":     append([]WhatToShow{ShowTrades}, quoteShows...),
+	"FUT":     append([]WhatToShow{ShowTrades}, quoteShows...),
+	"CONTFUT": append([]WhatToShow{ShowTrades}, quoteShows...),
+	"WAR":     append([]WhatToShow{ShowTrades}, quoteShows...),
+	"CASH":    quoteShows,
+	"CMDTY":   quoteShows,
+	"CFD":     quoteShows,
+	"BOND":    append([]WhatToShow{ShowTrades, ShowYieldBid, ShowYieldAsk, ShowYieldBidAsk, ShowYieldLast}, quoteShows...),
+}
+
+const (
+	DateFormatString int64 = 1 // "20160328  09:30:00" in the gateway's time zone
+	DateFormatEpoch  int64 = 2 // seconds since the epoch
+)
+
+// barSizeRange is a row of the duration/bar size table: requests up to
+// MaxDuration accept bars from Min to Max.
+type barSizeRange struct {
+	MaxDuration time.Duration
+	Min         BarSize
+	Max         BarSize
+}
+
+// BAR_SIZE_RANGES is the gateway's table of valid bar sizes per duration,
+// ordered by duration.
+var BAR_SIZE_RANGES = []barSizeRange{
+	{60 * time.Second, Bar1Sec, Bar1Min},
+	{120 * time.Second, Bar1Sec, Bar2Mins},
+	{1800 * time.Second, Bar1Sec, Bar30Mins},
+	{3600 * time.Second, Bar5Secs, Bar1Hour},
+	{14400 * time.Second, Bar10Secs, Bar3Hours},
+	{28800 * time.Second, Bar30Secs, Bar8Hours},
+	{24 * time.Hour, Bar1Min, Bar1Day},
+	{2 * 24 * time.Hour, Bar2Mins, Bar1Day},
+	{7 * 24 * time.Hour, Bar3Mins, Bar1Week},
+	{31 * 24 * time.Hour, Bar30Mins, Bar1Month},
+	{365 * 24 * time.Hour, Bar1Day, Bar1Month},
+}
+
+// CheckBarSize reports whether bars of size s can be requested for duration d.
+func CheckBarSize(d Duration, s BarSize) error {
+	span, ok := d.Approx()
+
+	if !ok || d.N <= 0 {
+		return fmt.Errorf("invalid duration %q", d.String())
+	}
+
+	bar, ok := s.Duration()
+
+	if !ok {
+		return fmt.Errorf("invalid bar size %q", s)
+	}
+
+	if d.Unit == Seconds && span > 24*time.Hour {
+		return fmt.Errorf("duration %q exceeds 86400 seconds", d.String())
+	}
+
+	for _, r := range BAR_SIZE_RANGES {
+		if span > r.MaxDuration {
+			continue
+		}
+
+		min, _ := r.Min.Duration()
+		max, _ := r.Max.Duration()
+
+		if bar < min || bar > max {
+			return fmt.Errorf("duration %q allows bar sizes from %q to %q, not %q", d.String(), r.Min, r.Max, s)
+		}
+
+		return nil
+	}
+
+	if bar < 24*time.Hour {
+		return fmt.Errorf("duration %q allows bar sizes from %q, not %q", d.String(), Bar1Day, s)
+	}
+
+	return nil
+}
+
+// CheckWhatToShow reports whether w is available for a security type.
+func CheckWhatToShow(securityType string, w WhatToShow) error {
+	l, ok := WHAT_TO_SHOW[strings.ToUpper(securityType)]
+
+	if !ok {
+		return nil
+	}
+
+	for _, v := range l {
+		if v == w {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not available for %s contracts", w, securityType)
+}
+
+// FormatHistoricalEnd writes an end time for a request. Times are sent in
+// UTC so that the gateway does not have to know the time zone; the zero time
+// asks for data up to now.
+func FormatHistoricalEnd(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format("20060102 15:04:05") + " GMT"
+}
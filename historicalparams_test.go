@@ -0,0 +1,109 @@
+package ib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Duration
+	}{
+		{"60 S", Duration{60, Seconds}},
+		{"3 D", Duration{3, Days}},
+		{"2 w", Duration{2, Weeks}},
+		{" 6  M ", Duration{6, Months}},
+		{"1 Y", Duration{1, Years}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.s)
+
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %v", tt.s, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "3", "3D", "0 D", "-1 D", "1.5 D", "3 H", "3 D 1"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q): expected an error", s)
+		}
+	}
+}
+
+func TestDurationString(t *testing.T) {
+	for _, s := range []string{"60 S", "3 D", "1 Y"} {
+		d, err := ParseDuration(s)
+
+		if err != nil || d.String() != s {
+			t.Errorf("ParseDuration(%q).String() = %q, %v", s, d.String(), err)
+		}
+	}
+}
+
+func TestCheckBarSize(t *testing.T) {
+	tests := []struct {
+		d  Duration
+		s  BarSize
+		ok bool
+	}{
+		{Duration{60, Seconds}, Bar1Sec, true},
+		{Duration{60, Seconds}, Bar1Min, true},
+		{Duration{60, Seconds}, Bar2Mins, false},
+		{Duration{3600, Seconds}, Bar1Sec, false},
+		{Duration{3600, Seconds}, Bar5Secs, true},
+		{Duration{3600, Seconds}, Bar1Hour, true},
+		{Duration{86400, Seconds}, Bar1Min, true},
+		{Duration{86401, Seconds}, Bar1Day, false},
+		{Duration{1, Days}, Bar30Secs, false},
+		{Duration{1, Days}, Bar1Min, true},
+		{Duration{1, Days}, Bar1Day, true},
+		{Duration{2, Days}, Bar1Min, false},
+		{Duration{1, Weeks}, Bar3Mins, true},
+		{Duration{1, Weeks}, Bar1Week, true},
+		{Duration{1, Weeks}, Bar1Month, false},
+		{Duration{1, Months}, Bar15Mins, false},
+		{Duration{1, Months}, Bar30Mins, true},
+		{Duration{1, Years}, Bar1Day, true},
+		{Duration{1, Years}, Bar8Hours, false},
+		{Duration{2, Years}, Bar1Week, true},
+		{Duration{2, Years}, Bar1Hour, false},
+		{Duration{0, Days}, Bar1Day, false},
+		{Duration{1, "H"}, Bar1Min, false},
+		{Duration{1, Days}, BarSize("7 mins"), false},
+	}
+
+	for _, tt := range tests {
+		if err := CheckBarSize(tt.d, tt.s); (err == nil) != tt.ok {
+			t.Errorf("CheckBarSize(%v, %q) = %v, want ok %v", tt.d, tt.s, err, tt.ok)
+		}
+	}
+}
+
+func TestFormatHistoricalEnd(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	tok := mustLoad(t, "JST")
+
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Time{}, ""},
+		{time.Date(2016, 3, 28, 16, 0, 0, 0, time.UTC), "20160328 16:00:00 GMT"},
+		{time.Date(2016, 3, 28, 9, 30, 0, 0, ny), "20160328 13:30:00 GMT"},
+		{time.Date(2016, 1, 4, 9, 30, 0, 0, ny), "20160104 14:30:00 GMT"},
+		{time.Date(2016, 3, 29, 8, 0, 15, 0, tok), "20160328 23:00:15 GMT"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatHistoricalEnd(tt.t); got != tt.want {
+			t.Errorf("FormatHistoricalEnd(%v) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
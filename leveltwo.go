@@ -0,0 +1,268 @@
+package ib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// LEVEL II BOOK
+////////////////////////////////////////////////////////////////////////////////
+
+// MarketMakerQuote is one row of a Level II book: the quote of a market maker,
+// or of an exchange for SMART depth.
+type MarketMakerQuote struct {
+	MarketMaker string
+	Price       float64
+	Size        int64
+}
+
+// PriceLevel aggregates the quotes at one price.
+type PriceLevel struct {
+	Price        float64
+	Size         int64
+	MarketMakers []string
+}
+
+// LevelTwoBook rebuilds the per market maker book of a depth subscription
+// from MarketDepthLevelTwo updates, which are sent by position like level one
+// updates. Like OrderBook it is stale, and its reads fail or return nothing,
+// while OrderBooks waits for the gateway to resend it.
+type LevelTwoBook struct {
+	Contract Contract
+	rid      int64
+	rows     int64
+	stale    bool
+	bids     []MarketMakerQuote
+	asks     []MarketMakerQuote
+	updated  time.Time
+	mu       sync.RWMutex
+}
+
+func NewLevelTwoBook(rid int64, c Contract) *LevelTwoBook {
+	return &LevelTwoBook{Contract: c, rid: rid}
+}
+
+func (o *LevelTwoBook) Rid() int64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.rid
+}
+
+func (o *LevelTwoBook) Stale() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.stale
+}
+
+func (o *LevelTwoBook) restart(rid, rows int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.rid = rid
+	o.rows = rows
+	o.stale = true
+	o.bids, o.asks = nil, nil
+	o.updated = time.Now()
+}
+
+// Apply applies one level two operation. Like OrderBook.Apply it returns an
+// error, leaving the book unchanged, for operations that do not fit the book.
+func (o *LevelTwoBook) Apply(operation, side, position int64, marketMaker string, price float64, size int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var l *[]MarketMakerQuote
+
+	switch side {
+	case DepthBid:
+		l = &o.bids
+	case DepthAsk:
+		l = &o.asks
+	default:
+		return fmt.Errorf("level two book %d: invalid side %d", o.rid, side)
+	}
+
+	n := int64(len(*l))
+	q := MarketMakerQuote{marketMaker, price, size}
+
+	switch operation {
+	case DepthInsert:
+		if position < 0 || position > n {
+			return fmt.Errorf("level two book %d: insert at position %d of %d rows", o.rid, position, n)
+		}
+
+		*l = append(*l, MarketMakerQuote{})
+		copy((*l)[position+1:], (*l)[position:])
+		(*l)[position] = q
+	case DepthUpdate:
+		if position < 0 || position >= n {
+			return fmt.Errorf("level two book %d: update of position %d of %d rows", o.rid, position, n)
+		}
+
+		(*l)[position] = q
+	case DepthDelete:
+		if position < 0 || position >= n {
+			return fmt.Errorf("level two book %d: delete of position %d of %d rows", o.rid, position, n)
+		}
+
+		*l = append((*l)[:position], (*l)[position+1:]...)
+	default:
+		return fmt.Errorf("level two book %d: invalid operation %d", o.rid, operation)
+	}
+
+	o.updated = time.Now()
+
+	if o.stale && (operation != DepthInsert || o.rows > 0 && int64(len(o.bids)) >= o.rows && int64(len(o.asks)) >= o.rows) {
+		o.stale = false
+	}
+
+	return nil
+}
+
+func (o *LevelTwoBook) ApplyLevelTwo(d *MarketDepthLevelTwo) error {
+	return o.Apply(d.Operation, d.Side, d.Position, d.MarketMaker, d.Price, d.Size)
+}
+
+func (o *LevelTwoBook) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.bids, o.asks = nil, nil
+	o.updated = time.Now()
+}
+
+// Quotes returns the rows of one side by position.
+func (o *LevelTwoBook) Quotes(side int64) []MarketMakerQuote {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.stale {
+		return nil
+	}
+
+	if side == DepthBid {
+		return append([]MarketMakerQuote(nil), o.bids...)
+	}
+
+	return append([]MarketMakerQuote(nil), o.asks...)
+}
+
+// MarketMaker returns the bid and ask rows of one market maker.
+func (o *LevelTwoBook) MarketMaker(mm string) ([]MarketMakerQuote, []MarketMakerQuote) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	var bids, asks []MarketMakerQuote
+
+	if o.stale {
+		return nil, nil
+	}
+
+	for _, q := range o.bids {
+		if q.MarketMaker == mm {
+			bids = append(bids, q)
+		}
+	}
+
+	for _, q := range o.asks {
+		if q.MarketMaker == mm {
+			asks = append(asks, q)
+		}
+	}
+
+	return bids, asks
+}
+
+// Levels aggregates one side by price, best price first.
+func (o *LevelTwoBook) Levels(side int64) []PriceLevel {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.stale {
+		return nil
+	}
+
+	return aggregateQuotes(o.sideOf(side), side)
+}
+
+func (o *LevelTwoBook) sideOf(side int64) []MarketMakerQuote {
+	if side == DepthBid {
+		return o.bids
+	}
+
+	return o.asks
+}
+
+func aggregateQuotes(l []MarketMakerQuote, side int64) []PriceLevel {
+	var r []PriceLevel
+	index := make(map[float64]int)
+
+	for _, q := range l {
+		i, ok := index[q.Price]
+
+		if !ok {
+			i = len(r)
+			index[q.Price] = i
+			r = append(r, PriceLevel{Price: q.Price})
+		}
+
+		r[i].Size += q.Size
+		r[i].MarketMakers = append(r[i].MarketMakers, q.MarketMaker)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if side == DepthBid {
+			return r[i].Price > r[j].Price
+		}
+		return r[i].Price < r[j].Price
+	})
+
+	return r
+}
+
+// InsideBid returns the best bid price with its total size and the market
+// makers quoting it.
+func (o *LevelTwoBook) InsideBid() (PriceLevel, bool) {
+	return o.inside(DepthBid)
+}
+
+func (o *LevelTwoBook) InsideAsk() (PriceLevel, bool) {
+	return o.inside(DepthAsk)
+}
+
+func (o *LevelTwoBook) inside(side int64) (PriceLevel, bool) {
+	l := o.Levels(side)
+
+	if len(l) == 0 {
+		return PriceLevel{}, false
+	}
+
+	return l[0], true
+}
+
+// OrderBook returns the aggregated book as a BookSnapshot, or ErrBookStale.
+func (o *LevelTwoBook) OrderBook() (BookSnapshot, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	s := BookSnapshot{Rid: o.rid, Contract: o.Contract, Updated: o.updated}
+
+	if o.stale {
+		return s, ErrBookStale
+	}
+
+	for _, p := range aggregateQuotes(o.bids, DepthBid) {
+		s.Bids = append(s.Bids, BookLevel{p.Price, p.Size})
+	}
+
+	for _, p := range aggregateQuotes(o.asks, DepthAsk) {
+		s.Asks = append(s.Asks, BookLevel{p.Price, p.Size})
+	}
+
+	return s, nil
+}
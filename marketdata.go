@@ -1,9 +1,11 @@
 package ib
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -24,7 +26,7 @@ func init() {
 }
 
 func (r *MarketDataRequest) Send(b *MarketDataBroker) {
-	b.Contracts[r.Rid] = r.Contract
+	b.setContract(r.Rid, r.Contract)
 	b.WriteInt(REQUEST_CODE["MarketData"])
 	b.WriteInt(REQUEST_VERSION["MarketData"])
 	b.WriteInt(r.Rid)
@@ -63,7 +65,54 @@ func (r *CancelMarketDataRequest) Send(b *MarketDataBroker) {
 
 	b.Broker.SendRequest()
 
-	delete(b.Contracts, r.Rid)
+	b.forgetContract(r.Rid)
+}
+
+type MarketDataMode int64
+
+const (
+	RealTimeData      MarketDataMode = 1
+	FrozenData        MarketDataMode = 2
+	DelayedData       MarketDataMode = 3
+	DelayedFrozenData MarketDataMode = 4
+)
+
+func (m MarketDataMode) Delayed() bool {
+	return m == DelayedData || m == DelayedFrozenData
+}
+
+func (m MarketDataMode) String() string {
+	switch m {
+	case RealTimeData:
+		return "REALTIME"
+	case FrozenData:
+		return "FROZEN"
+	case DelayedData:
+		return "DELAYED"
+	case DelayedFrozenData:
+		return "DELAYED_FROZEN"
+	default:
+		return strconv.FormatInt(int64(m), 10)
+	}
+}
+
+// MarketDataTypeRequest switches the data sent for subsequent market data
+// requests, e.g. to delayed quotes for accounts without a subscription.
+type MarketDataTypeRequest struct {
+	Mode MarketDataMode
+}
+
+func init() {
+	REQUEST_CODE["MarketDataType"] = 59
+	REQUEST_VERSION["MarketDataType"] = 1
+}
+
+func (r *MarketDataTypeRequest) Send(b *MarketDataBroker) {
+	b.WriteInt(REQUEST_CODE["MarketDataType"])
+	b.WriteInt(REQUEST_VERSION["MarketDataType"])
+	b.WriteInt(int64(r.Mode))
+
+	b.Broker.SendRequest()
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -79,7 +128,7 @@ type TickPrice struct {
 	Right          string
 	Strike         float64
 	Expiry         string
-	TickType       int64
+	TickType       TickType
 	Price          float64
 	Size           int64
 	CanAutoExecute bool
@@ -91,7 +140,7 @@ func init() {
 
 type TickSize struct {
 	Rid      int64
-	TickType int64
+	TickType TickType
 	Size     int64
 }
 
@@ -101,7 +150,7 @@ func init() {
 
 type TickOptComp struct {
 	Rid         int64
-	TickType    int64
+	TickType    TickType
 	ImpliedVol  float64
 	Delta       float64
 	OptionPrice float64
@@ -113,12 +162,12 @@ type TickOptComp struct {
 }
 
 func init() {
-	RESPONSE_CODE["TicOptComp"] = "21"
+	RESPONSE_CODE["TickOptComp"] = "21"
 }
 
 type TickGeneric struct {
 	Rid      int64
-	TickType int64
+	TickType TickType
 	Value    float64
 }
 
@@ -128,7 +177,7 @@ func init() {
 
 type TickString struct {
 	Rid      int64
-	TickType int64
+	TickType TickType
 	Value    string
 }
 
@@ -138,7 +187,7 @@ func init() {
 
 type TickEFP struct {
 	Rid                  int64
-	TickType             int64
+	TickType             TickType
 	BasisPoints          float64
 	FormattedBasisPoints string
 	ImpliedFuturesPrice  float64
@@ -152,29 +201,60 @@ func init() {
 	RESPONSE_CODE["TickEFP"] = "47"
 }
 
+type TickSnapshotEnd struct {
+	Rid int64
+}
+
+func init() {
+	RESPONSE_CODE["TickSnapshotEnd"] = "57"
+}
+
 type MarketDataType struct {
 	Rid      int64
-	TickType int64
+	TickType int64 // the MarketDataMode of the request
+}
+
+func (r *MarketDataType) Mode() MarketDataMode {
+	return MarketDataMode(r.TickType)
 }
 
 func init() {
 	RESPONSE_CODE["MarketDataType"] = "58"
 }
 
+// MarketDataError is an error the gateway reported for a streaming market
+// data request, which ends the request.
+type MarketDataError struct {
+	Rid  int64
+	Code int64
+	Msg  string
+}
+
+func (e MarketDataError) Error() string {
+	return fmt.Sprintf("market data request %d: error %d: %s", e.Rid, e.Code, e.Msg)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // BROKER
 ////////////////////////////////////////////////////////////////////////////////
 
 type MarketDataBroker struct {
 	Broker
-	Contracts          map[int64]Contract
-	TickPriceChan      chan TickPrice
-	TickSizeChan       chan TickSize
-	TickOptCompChan    chan TickOptComp
-	TickGenericChan    chan TickGeneric
-	TickStringChan     chan TickString
-	TickEFPChan        chan TickEFP
-	MarketDataTypeChan chan MarketDataType
+	Contracts           map[int64]Contract
+	TickPriceChan       chan TickPrice
+	TickSizeChan        chan TickSize
+	TickOptCompChan     chan TickOptComp
+	TickGenericChan     chan TickGeneric
+	TickStringChan      chan TickString
+	TickEFPChan         chan TickEFP
+	MarketDataTypeChan  chan MarketDataType
+	TickSnapshotEndChan chan TickSnapshotEnd
+	MarketDataErrorChan chan MarketDataError
+	TickRules           *TickRules
+	snapshots           map[int64]*snapshotWaiter
+	calcs               map[int64]*optionCalcWaiter
+	discarded           *discardSet
+	mu                  *sync.Mutex
 }
 
 func NewMarketDataBroker() MarketDataBroker {
@@ -188,11 +268,42 @@ func NewMarketDataBroker() MarketDataBroker {
 		make(chan TickString),
 		make(chan TickEFP),
 		make(chan MarketDataType),
+		make(chan TickSnapshotEnd),
+		make(chan MarketDataError),
+		NewTickRules(),
+		make(map[int64]*snapshotWaiter),
+		make(map[int64]*optionCalcWaiter),
+		newDiscardSet(),
+		&sync.Mutex{},
 	}
 
 	return b
 }
 
+// Contract returns the contract of a market data request. Contracts are
+// written as requests are sent, so readers outside Listen go through this
+// rather than reading Contracts directly.
+func (b *MarketDataBroker) Contract(rid int64) Contract {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.Contracts[rid]
+}
+
+func (b *MarketDataBroker) setContract(rid int64, c Contract) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Contracts[rid] = c
+}
+
+func (b *MarketDataBroker) forgetContract(rid int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.Contracts, rid)
+}
+
 func (b *MarketDataBroker) Listen() {
 	for {
 		s, err := b.ReadString()
@@ -211,39 +322,109 @@ func (b *MarketDataBroker) Listen() {
 			switch s {
 			case RESPONSE_CODE["TickPrice"]:
 				r := b.ReadTickPrice(s, version)
+				if b.collect(r.Rid, r) {
+					continue
+				}
 				b.TickPriceChan <- r
 			case RESPONSE_CODE["TickSize"]:
 				r := b.ReadTickSize(s, version)
+				if b.collect(r.Rid, r) {
+					continue
+				}
 				b.TickSizeChan <- r
 			case RESPONSE_CODE["TickOptComp"]:
 				r := b.ReadTickOptComp(s, version)
+				if b.completeCalc(r) || b.collect(r.Rid, r) {
+					continue
+				}
 				b.TickOptCompChan <- r
 			case RESPONSE_CODE["TickGeneric"]:
 				r := b.ReadTickGeneric(s, version)
+				if b.collect(r.Rid, r) {
+					continue
+				}
 				b.TickGenericChan <- r
 			case RESPONSE_CODE["TickString"]:
 				r := b.ReadTickString(s, version)
+				if b.collect(r.Rid, r) {
+					continue
+				}
 				b.TickStringChan <- r
 			case RESPONSE_CODE["TickEFP"]:
 				r := b.ReadTickEFP(s, version)
+				if b.collect(r.Rid, r) {
+					continue
+				}
 				b.TickEFPChan <- r
-				//			case RESPONSE.CODE.TICK_SNAPSHOT_END:
+			case RESPONSE_CODE["TickSnapshotEnd"]:
+				r := b.ReadTickSnapshotEnd(s, version)
+				if w := b.releaseSnapshot(r.Rid); w != nil {
+					close(w.done)
+					continue
+				}
+				if b.discarded.Has(r.Rid) {
+					continue
+				}
+				b.TickSnapshotEndChan <- r
 			case RESPONSE_CODE["MarketDataType"]:
 				r := b.ReadMarketDataType(s, version)
 				b.MarketDataTypeChan <- r
 			default:
 				b.ReadString()
 			}
+		} else {
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			id, code, msg := b.ReadErrMsg(version)
+
+			if IsWarningCode(code) {
+				b.warnSnapshot(id, code, msg)
+				continue
+			}
+
+			if w := b.releaseSnapshot(id); w != nil {
+				w.err = fmt.Errorf("market data request %d: error %d: %s", id, code, msg)
+				close(w.done)
+				continue
+			}
+
+			if b.failCalc(id, code, msg) || b.discarded.Has(id) {
+				continue
+			}
+
+			if b.streaming(id) {
+				b.MarketDataErrorChan <- MarketDataError{id, code, msg}
+			}
 		}
 	}
 }
 
+// streaming reports whether id is a market data request that has been sent
+// and not cancelled.
+func (b *MarketDataBroker) streaming(id int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.Contracts[id]
+
+	return ok
+}
+
+func (b *MarketDataBroker) ReadTickType() (TickType, error) {
+	t, err := b.ReadInt()
+	return TickType(t), err
+}
+
 func (b *MarketDataBroker) ReadTickPrice(code, version string) TickPrice {
 	var r TickPrice
 
 	r.Rid, _ = b.ReadInt()
 
-	c := b.Contracts[r.Rid]
+	c := b.Contract(r.Rid)
 
 	r.Symbol = c.Symbol
 	r.SecurityType = c.SecurityType
@@ -252,11 +433,15 @@ func (b *MarketDataBroker) ReadTickPrice(code, version string) TickPrice {
 	r.Right = c.Right
 	r.Strike = c.Strike
 	r.Expiry = c.Expiry
-	r.TickType, _ = b.ReadInt()
+	r.TickType, _ = b.ReadTickType()
 	r.Price, _ = b.ReadFloat()
 	r.Size, _ = b.ReadInt()
 	r.CanAutoExecute, _ = b.ReadBool()
 
+	if d, err := b.TickRules.Get(&c); err == nil {
+		r.Price = d.ScalePrice(r.Price)
+	}
+
 	return r
 }
 
@@ -264,25 +449,53 @@ func (b *MarketDataBroker) ReadTickSize(code, version string) TickSize {
 	var r TickSize
 
 	r.Rid, _ = b.ReadInt()
-	r.TickType, _ = b.ReadInt()
+	r.TickType, _ = b.ReadTickType()
 	r.Size, _ = b.ReadInt()
 
 	return r
 }
 
+// ReadTickOptComp decodes an option computation according to its version.
+// Values the gateway does not know, or that older versions do not send, are
+// left at the -1 (prices and volatility) or -2 (greeks) sentinels.
 func (b *MarketDataBroker) ReadTickOptComp(code, version string) TickOptComp {
-	var r TickOptComp
+	r := TickOptComp{
+		ImpliedVol:  -1,
+		Delta:       -2,
+		OptionPrice: -1,
+		PvDividend:  -1,
+		Gamma:       -2,
+		Vega:        -2,
+		Theta:       -2,
+		UndPrice:    -1,
+	}
+
+	ver, _ := strconv.ParseInt(version, 10, 64)
 
 	r.Rid, _ = b.ReadInt()
-	r.TickType, _ = b.ReadInt()
+	r.TickType, _ = b.ReadTickType()
 	r.ImpliedVol, _ = b.ReadFloat()
 	r.Delta, _ = b.ReadFloat()
-	r.OptionPrice, _ = b.ReadFloat()
-	r.PvDividend, _ = b.ReadFloat()
-	r.Gamma, _ = b.ReadFloat()
-	r.Vega, _ = b.ReadFloat()
-	r.Theta, _ = b.ReadFloat()
-	r.UndPrice, _ = b.ReadFloat()
+
+	if r.ImpliedVol < 0 {
+		r.ImpliedVol = -1
+	}
+
+	if r.Delta > 1 || r.Delta < -1 {
+		r.Delta = -2
+	}
+
+	if ver >= 6 || r.TickType == TickModelOption || r.TickType == TickDelayedModelOption {
+		r.OptionPrice, _ = b.ReadFloat()
+		r.PvDividend, _ = b.ReadFloat()
+	}
+
+	if ver >= 6 {
+		r.Gamma, _ = b.ReadFloat()
+		r.Vega, _ = b.ReadFloat()
+		r.Theta, _ = b.ReadFloat()
+		r.UndPrice, _ = b.ReadFloat()
+	}
 
 	return r
 }
@@ -291,7 +504,7 @@ func (b *MarketDataBroker) ReadTickGeneric(code, version string) TickGeneric {
 	var r TickGeneric
 
 	r.Rid, _ = b.ReadInt()
-	r.TickType, _ = b.ReadInt()
+	r.TickType, _ = b.ReadTickType()
 	r.Value, _ = b.ReadFloat()
 
 	return r
@@ -301,7 +514,7 @@ func (b *MarketDataBroker) ReadTickString(code, version string) TickString {
 	var r TickString
 
 	r.Rid, _ = b.ReadInt()
-	r.TickType, _ = b.ReadInt()
+	r.TickType, _ = b.ReadTickType()
 	r.Value, _ = b.ReadString()
 
 	return r
@@ -311,7 +524,7 @@ func (b *MarketDataBroker) ReadTickEFP(code, version string) TickEFP {
 	var r TickEFP
 
 	r.Rid, _ = b.ReadInt()
-	r.TickType, _ = b.ReadInt()
+	r.TickType, _ = b.ReadTickType()
 	r.BasisPoints, _ = b.ReadFloat()
 	r.FormattedBasisPoints, _ = b.ReadString()
 	r.ImpliedFuturesPrice, _ = b.ReadFloat()
@@ -323,6 +536,14 @@ func (b *MarketDataBroker) ReadTickEFP(code, version string) TickEFP {
 	return r
 }
 
+func (b *MarketDataBroker) ReadTickSnapshotEnd(code, version string) TickSnapshotEnd {
+	var r TickSnapshotEnd
+
+	r.Rid, _ = b.ReadInt()
+
+	return r
+}
+
 func (b *MarketDataBroker) ReadMarketDataType(code, version string) MarketDataType {
 	var r MarketDataType
 
@@ -332,39 +553,272 @@ func (b *MarketDataBroker) ReadMarketDataType(code, version string) MarketDataTy
 	return r
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// DISPATCH
+////////////////////////////////////////////////////////////////////////////////
+
+// MarketDataHandler receives a TickPrice, TickSize, TickOptComp, TickGeneric,
+// TickString, TickEFP, TickSnapshotEnd, MarketDataType or MarketDataError
+// value together with a copy of the contract of its request.
+type MarketDataHandler func(c Contract, t interface{})
+
+// MarketDataDispatcher is the single reader of the channels of a
+// MarketDataBroker. It hands every value to each registered handler in
+// registration order, so that QuoteBook, BarBuilder and SubscriptionManager
+// can share one broker. Handlers run on the dispatcher's goroutine; one that
+// blocks holds up the others.
+type MarketDataDispatcher struct {
+	Broker   *MarketDataBroker
+	handlers []MarketDataHandler
+	mu       sync.RWMutex
+}
+
+func NewMarketDataDispatcher(b *MarketDataBroker) *MarketDataDispatcher {
+	return &MarketDataDispatcher{Broker: b}
+}
+
+func (d *MarketDataDispatcher) Register(h MarketDataHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers = append(d.handlers, h)
+}
+
+// Run consumes the channels of the broker until done is closed. It is
+// normally started in its own goroutine next to Broker.Listen.
+func (d *MarketDataDispatcher) Run(done <-chan struct{}) {
+	b := d.Broker
+
+	for {
+		select {
+		case r := <-b.TickPriceChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.TickSizeChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.TickOptCompChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.TickGenericChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.TickStringChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.TickEFPChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.TickSnapshotEndChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.MarketDataTypeChan:
+			d.dispatch(r.Rid, r)
+		case r := <-b.MarketDataErrorChan:
+			d.dispatch(r.Rid, r)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *MarketDataDispatcher) dispatch(rid int64, t interface{}) {
+	c := d.Broker.Contract(rid)
+
+	d.mu.RLock()
+	l := d.handlers
+	d.mu.RUnlock()
+
+	for _, h := range l {
+		h(c, t)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SNAPSHOTS
+////////////////////////////////////////////////////////////////////////////////
+
+// SNAPSHOT_TIMEOUT bounds a Snapshot whose context has no deadline. The
+// gateway ends snapshots after about 11 seconds.
+var SNAPSHOT_TIMEOUT = 12 * time.Second
+
+// MarketDataSnapshot holds the last tick of each type received for a snapshot
+// request, keyed by tick type.
+type MarketDataSnapshot struct {
+	Rid      int64
+	Contract Contract
+	Prices   map[TickType]TickPrice
+	Sizes    map[TickType]TickSize
+	OptComps map[TickType]TickOptComp
+	Generics map[TickType]TickGeneric
+	Strings  map[TickType]TickString
+	EFPs     map[TickType]TickEFP
+	Warnings []string // warning messages received for the request
+	Complete bool     // TickSnapshotEnd was received
+}
+
+type snapshotWaiter struct {
+	snapshot MarketDataSnapshot
+	err      error
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+func (w *snapshotWaiter) add(t interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := &w.snapshot
+
+	switch r := t.(type) {
+	case TickPrice:
+		s.Prices[r.TickType] = r
+	case TickSize:
+		s.Sizes[r.TickType] = r
+	case TickOptComp:
+		s.OptComps[r.TickType] = r
+	case TickGeneric:
+		s.Generics[r.TickType] = r
+	case TickString:
+		s.Strings[r.TickType] = r
+	case TickEFP:
+		s.EFPs[r.TickType] = r
+	}
+}
+
+// Snapshot requests a market data snapshot for c and gathers every tick until
+// TickSnapshotEnd arrives. Warnings such as delayed data being shown are kept
+// in the snapshot; other errors end it. When ctx is done first, the partial
+// snapshot is returned together with the context error. Listen must be
+// running.
+func (b *MarketDataBroker) Snapshot(ctx context.Context, c Contract, genericTicks string) (MarketDataSnapshot, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, SNAPSHOT_TIMEOUT)
+		defer cancel()
+	}
+
+	b.mu.Lock()
+	id := b.NextReqId()
+	w := &snapshotWaiter{
+		snapshot: MarketDataSnapshot{
+			Rid:      id,
+			Contract: c,
+			Prices:   make(map[TickType]TickPrice),
+			Sizes:    make(map[TickType]TickSize),
+			OptComps: make(map[TickType]TickOptComp),
+			Generics: make(map[TickType]TickGeneric),
+			Strings:  make(map[TickType]TickString),
+			EFPs:     make(map[TickType]TickEFP),
+		},
+		done: make(chan struct{}),
+	}
+	b.snapshots[id] = w
+	b.mu.Unlock()
+
+	r := MarketDataRequest{id, c, genericTicks, true}
+	r.Send(b)
+
+	select {
+	case <-w.done:
+		w.snapshot.Complete = w.err == nil
+		return w.snapshot, w.err
+	case <-ctx.Done():
+		b.discarded.Add(id)
+		b.releaseSnapshot(id)
+
+		cancel := CancelMarketDataRequest{id}
+		cancel.Send(b)
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		return w.snapshot.copy(), ctx.Err()
+	}
+}
+
+func (s *MarketDataSnapshot) copy() MarketDataSnapshot {
+	r := *s
+	r.Prices = make(map[TickType]TickPrice, len(s.Prices))
+	r.Sizes = make(map[TickType]TickSize, len(s.Sizes))
+	r.OptComps = make(map[TickType]TickOptComp, len(s.OptComps))
+	r.Generics = make(map[TickType]TickGeneric, len(s.Generics))
+	r.Strings = make(map[TickType]TickString, len(s.Strings))
+	r.EFPs = make(map[TickType]TickEFP, len(s.EFPs))
+	r.Warnings = append([]string(nil), s.Warnings...)
+
+	for k, v := range s.Prices {
+		r.Prices[k] = v
+	}
+	for k, v := range s.Sizes {
+		r.Sizes[k] = v
+	}
+	for k, v := range s.OptComps {
+		r.OptComps[k] = v
+	}
+	for k, v := range s.Generics {
+		r.Generics[k] = v
+	}
+	for k, v := range s.Strings {
+		r.Strings[k] = v
+	}
+	for k, v := range s.EFPs {
+		r.EFPs[k] = v
+	}
+
+	return r
+}
+
+// collect hands a tick to the pending snapshot for rid and reports whether
+// the tick was consumed, which it also is when the snapshot was abandoned.
+func (b *MarketDataBroker) collect(rid int64, t interface{}) bool {
+	b.mu.Lock()
+	w := b.snapshots[rid]
+	b.mu.Unlock()
+
+	if w == nil {
+		return b.discarded.Has(rid)
+	}
+
+	w.add(t)
+
+	return true
+}
+
+func (b *MarketDataBroker) warnSnapshot(rid, code int64, msg string) {
+	b.mu.Lock()
+	w := b.snapshots[rid]
+	b.mu.Unlock()
+
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.snapshot.Warnings = append(w.snapshot.Warnings, fmt.Sprintf("warning %d: %s", code, msg))
+}
+
+// releaseSnapshot removes the waiter of a snapshot request together with the
+// contract it registered.
+func (b *MarketDataBroker) releaseSnapshot(rid int64) *snapshotWaiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w, ok := b.snapshots[rid]
+
+	if ok {
+		delete(b.snapshots, rid)
+		delete(b.Contracts, rid)
+	}
+
+	return w
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // SERIALIZERS
 ////////////////////////////////////////////////////////////////////////////////
 
 func (b *MarketDataBroker) TickTypeToString(t int64) string {
-	switch t {
-	case 0:
-		return "BID SIZE"
-	case 1:
-		return "BID"
-	case 2:
-		return "ASK"
-	case 3:
-		return "ASK_SIZE"
-	case 4:
-		return "LAST"
-	case 5:
-		return "LAST SIZE"
-	case 6:
-		return "HIGH"
-	case 7:
-		return "LOW"
-	case 8:
-		return "VOLUME"
-	case 9:
-		return "CLOSE"
-	default:
-		return strconv.FormatInt(t, 10)
-	}
+	return TickType(t).LegacyString()
 }
 
 func (b *MarketDataBroker) PriceToJSON(d *TickPrice) ([]byte, error) {
-	c := b.Contracts[d.Rid]
+	c := b.Contract(d.Rid)
 	return json.Marshal(struct {
 		Rid          int64
 		Time         string
@@ -388,14 +842,14 @@ func (b *MarketDataBroker) PriceToJSON(d *TickPrice) ([]byte, error) {
 		Right:        c.Right,
 		Strike:       c.Strike,
 		Expiry:       c.Expiry,
-		TickType:     b.TickTypeToString(d.TickType),
+		TickType:     b.TickTypeToString(int64(d.TickType)),
 		Price:        d.Price,
 		Size:         d.Size,
 	})
 }
 
 func (b *MarketDataBroker) PriceToCSV(d *TickPrice) string {
-	c := b.Contracts[d.Rid]
+	c := b.Contract(d.Rid)
 	return fmt.Sprintf(
 		"%d,%s,%s,%s,%s,%s,%s,%.2f,%s,%s,%.2f,%d",
 		d.Rid,
@@ -407,7 +861,7 @@ func (b *MarketDataBroker) PriceToCSV(d *TickPrice) string {
 		c.Right,
 		c.Strike,
 		c.Expiry,
-		b.TickTypeToString(d.TickType),
+		b.TickTypeToString(int64(d.TickType)),
 		d.Price,
 		d.Size,
 	)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -23,7 +24,7 @@ func init() {
 }
 
 func (r *MarketDepthRequest) Send(b *MarketDepthBroker) {
-	b.Contracts[r.Rid] = r.Contract
+	b.setContract(r.Rid, r.Contract)
 	b.WriteInt(REQUEST_CODE["MarketDepth"])
 	b.WriteInt(REQUEST_VERSION["MarketDepth"])
 	b.WriteInt(r.Rid)
@@ -59,7 +60,7 @@ func (r *CancelMarketDepthRequest) Send(b *MarketDepthBroker) {
 
 	b.Broker.SendRequest()
 
-	delete(b.Contracts, r.Rid)
+	b.forgetContract(r.Rid)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -109,6 +110,7 @@ type MarketDepthBroker struct {
 	Contracts               map[int64]Contract
 	MarketDepthChan         chan MarketDepth
 	MarketDepthLevelTwoChan chan MarketDepthLevelTwo
+	mu                      *sync.Mutex
 }
 
 func NewMarketDepthBroker() MarketDepthBroker {
@@ -117,11 +119,36 @@ func NewMarketDepthBroker() MarketDepthBroker {
 		make(map[int64]Contract),
 		make(chan MarketDepth),
 		make(chan MarketDepthLevelTwo),
+		&sync.Mutex{},
 	}
 
 	return b
 }
 
+// Contract returns the contract of a market depth request. Contracts are
+// written as requests are sent, including resubscriptions by OrderBooks, so
+// readers go through this rather than reading Contracts directly.
+func (b *MarketDepthBroker) Contract(rid int64) Contract {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.Contracts[rid]
+}
+
+func (b *MarketDepthBroker) setContract(rid int64, c Contract) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Contracts[rid] = c
+}
+
+func (b *MarketDepthBroker) forgetContract(rid int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.Contracts, rid)
+}
+
 func (b *MarketDepthBroker) Listen() {
 	for {
 		s, err := b.ReadString()
@@ -156,7 +183,7 @@ func (b *MarketDepthBroker) ReadMarketDepth(code, version string) MarketDepth {
 
 	r.Rid, _ = b.ReadInt()
 
-	c := b.Contracts[r.Rid]
+	c := b.Contract(r.Rid)
 
 	r.Symbol = c.Symbol
 	r.SecurityType = c.SecurityType
@@ -217,7 +244,7 @@ func (b *MarketDepthBroker) OperationToString(o int64) string {
 }
 
 func (b *MarketDepthBroker) DepthToJSON(d *MarketDepth) ([]byte, error) {
-	c := b.Contracts[d.Rid]
+	c := b.Contract(d.Rid)
 	return json.Marshal(struct {
 		Rid          int64
 		Time         string
@@ -252,7 +279,7 @@ func (b *MarketDepthBroker) DepthToJSON(d *MarketDepth) ([]byte, error) {
 }
 
 func (b *MarketDepthBroker) DepthToCSV(d *MarketDepth) string {
-	c := b.Contracts[d.Rid]
+	c := b.Contract(d.Rid)
 	return fmt.Sprintf(
 		"%d,%s,%s,%s,%s,%s,%s,%.2f,%s,,%d,%s,%s,%.2f,%d",
 		d.Rid,
@@ -271,3 +298,64 @@ func (b *MarketDepthBroker) DepthToCSV(d *MarketDepth) string {
 		d.Size,
 	)
 }
+
+func (b *MarketDepthBroker) LevelTwoToJSON(d *MarketDepthLevelTwo) ([]byte, error) {
+	c := b.Contract(d.Rid)
+	return json.Marshal(struct {
+		Rid          int64
+		Time         string
+		Symbol       string
+		SecurityType string
+		Exchange     string
+		Currency     string
+		Right        string
+		Strike       float64
+		Expiry       string
+		MarketMaker  string
+		Position     int64
+		Operation    string
+		Side         string
+		Price        float64
+		Size         int64
+	}{
+		Rid:          d.Rid,
+		Time:         strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		Symbol:       c.Symbol,
+		SecurityType: c.SecurityType,
+		Exchange:     c.Exchange,
+		Currency:     c.Currency,
+		Right:        c.Right,
+		Strike:       c.Strike,
+		Expiry:       c.Expiry,
+		MarketMaker:  d.MarketMaker,
+		Position:     d.Position,
+		Operation:    b.OperationToString(d.Operation),
+		Side:         b.SideToString(d.Side),
+		Price:        d.Price,
+		Size:         d.Size,
+	})
+}
+
+// LevelTwoToCSV writes the same columns as DepthToCSV, with the market maker
+// in the column DepthToCSV leaves empty.
+func (b *MarketDepthBroker) LevelTwoToCSV(d *MarketDepthLevelTwo) string {
+	c := b.Contract(d.Rid)
+	return fmt.Sprintf(
+		"%d,%s,%s,%s,%s,%s,%s,%.2f,%s,%s,%d,%s,%s,%.2f,%d",
+		d.Rid,
+		strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		c.Symbol,
+		c.SecurityType,
+		c.Exchange,
+		c.Currency,
+		c.Right,
+		c.Strike,
+		c.Expiry,
+		d.MarketMaker,
+		d.Position,
+		b.OperationToString(d.Operation),
+		b.SideToString(d.Side),
+		d.Price,
+		d.Size,
+	)
+}
@@ -0,0 +1,220 @@
+package ib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// REQUESTS
+////////////////////////////////////////////////////////////////////////////////
+
+// NewsBulletinsRequest subscribes to news bulletins. With AllMessages set the
+// bulletins of the current day are sent first.
+type NewsBulletinsRequest struct {
+	AllMessages bool
+}
+
+func init() {
+	REQUEST_CODE["NewsBulletins"] = 12
+	REQUEST_VERSION["NewsBulletins"] = 1
+}
+
+func (r *NewsBulletinsRequest) Send(b *NewsBroker) {
+	b.WriteInt(REQUEST_CODE["NewsBulletins"])
+	b.WriteInt(REQUEST_VERSION["NewsBulletins"])
+	b.WriteBool(r.AllMessages)
+
+	b.Broker.SendRequest()
+}
+
+type CancelNewsBulletinsRequest struct{}
+
+func init() {
+	REQUEST_CODE["CancelNewsBulletins"] = 13
+	REQUEST_VERSION["CancelNewsBulletins"] = 1
+}
+
+func (r *CancelNewsBulletinsRequest) Send(b *NewsBroker) {
+	b.WriteInt(REQUEST_CODE["CancelNewsBulletins"])
+	b.WriteInt(REQUEST_VERSION["CancelNewsBulletins"])
+
+	b.Broker.SendRequest()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RESPONSES
+////////////////////////////////////////////////////////////////////////////////
+
+type NewsBulletinType int64
+
+const (
+	RegularBulletin     NewsBulletinType = 1
+	ExchangeUnavailable NewsBulletinType = 2 // the exchange is no longer available for trading
+	ExchangeAvailable   NewsBulletinType = 3 // the exchange is available again
+)
+
+func (t NewsBulletinType) String() string {
+	switch t {
+	case RegularBulletin:
+		return "REGULAR"
+	case ExchangeUnavailable:
+		return "EXCHANGE_UNAVAILABLE"
+	case ExchangeAvailable:
+		return "EXCHANGE_AVAILABLE"
+	default:
+		return strconv.FormatInt(int64(t), 10)
+	}
+}
+
+type NewsBulletin struct {
+	MsgId          int64
+	Type           NewsBulletinType
+	Message        string
+	OriginExchange string
+}
+
+func init() {
+	RESPONSE_CODE["NewsBulletin"] = "14"
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BROKER
+////////////////////////////////////////////////////////////////////////////////
+
+// NewsBroker delivers news bulletins and keeps track of the exchanges that
+// bulletins reported as unavailable.
+type NewsBroker struct {
+	Broker
+	NewsBulletinChan chan NewsBulletin
+	unavailable      map[string]NewsBulletin
+	mu               *sync.Mutex
+}
+
+func NewNewsBroker() NewsBroker {
+	b := NewsBroker{
+		Broker{},
+		make(chan NewsBulletin),
+		make(map[string]NewsBulletin),
+		&sync.Mutex{},
+	}
+
+	return b
+}
+
+func (b *NewsBroker) Subscribe(allMessages bool) {
+	r := NewsBulletinsRequest{allMessages}
+	r.Send(b)
+}
+
+func (b *NewsBroker) Cancel() {
+	r := CancelNewsBulletinsRequest{}
+	r.Send(b)
+}
+
+func (b *NewsBroker) Listen() {
+	for {
+		s, err := b.ReadString()
+
+		if err != nil {
+			continue
+		}
+
+		if s == RESPONSE_CODE["NewsBulletin"] {
+			version, err := b.ReadString()
+
+			if err != nil {
+				continue
+			}
+
+			r := b.ReadNewsBulletin(version)
+			b.track(r)
+			b.NewsBulletinChan <- r
+		}
+	}
+}
+
+func (b *NewsBroker) ReadNewsBulletin(version string) NewsBulletin {
+	var r NewsBulletin
+	var t int64
+
+	r.MsgId, _ = b.ReadInt()
+	t, _ = b.ReadInt()
+	r.Type = NewsBulletinType(t)
+	r.Message, _ = b.ReadString()
+	r.OriginExchange, _ = b.ReadString()
+
+	return r
+}
+
+func (b *NewsBroker) track(r NewsBulletin) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch r.Type {
+	case ExchangeUnavailable:
+		b.unavailable[r.OriginExchange] = r
+	case ExchangeAvailable:
+		delete(b.unavailable, r.OriginExchange)
+	}
+}
+
+// ExchangeAvailable reports whether no bulletin received so far left exch
+// unavailable.
+func (b *NewsBroker) ExchangeAvailable(exch string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, down := b.unavailable[exch]
+
+	return !down
+}
+
+// UnavailableExchanges returns the bulletin that took down each exchange that
+// is currently unavailable.
+func (b *NewsBroker) UnavailableExchanges() map[string]NewsBulletin {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := make(map[string]NewsBulletin, len(b.unavailable))
+
+	for k, v := range b.unavailable {
+		r[k] = v
+	}
+
+	return r
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SERIALIZERS
+////////////////////////////////////////////////////////////////////////////////
+
+func (b *NewsBroker) NewsBulletinToJSON(d *NewsBulletin) ([]byte, error) {
+	return json.Marshal(struct {
+		Time           string
+		MsgId          int64
+		Type           string
+		Message        string
+		OriginExchange string
+	}{
+		Time:           strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		MsgId:          d.MsgId,
+		Type:           d.Type.String(),
+		Message:        d.Message,
+		OriginExchange: d.OriginExchange,
+	})
+}
+
+func (b *NewsBroker) NewsBulletinToCSV(d *NewsBulletin) string {
+	return fmt.Sprintf(
+		"%s,%d,%s,%q,%s",
+		strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		d.MsgId,
+		d.Type.String(),
+		d.Message,
+		d.OriginExchange,
+	)
+}
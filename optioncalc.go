@@ -0,0 +1,234 @@
+package ib
+
+import (
+	"context"
+	"fmt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// REQUESTS
+////////////////////////////////////////////////////////////////////////////////
+
+// CalcImpliedVolatilityRequest asks the gateway for the implied volatility of
+// an option at the given option and underlying prices. The result arrives as a
+// TickOptComp of type CUST_OPTION_COMPUTATION.
+type CalcImpliedVolatilityRequest struct {
+	Rid         int64
+	Contract    Contract
+	OptionPrice float64
+	UnderPrice  float64
+}
+
+func init() {
+	REQUEST_CODE["CalcImpliedVolatility"] = 54
+	REQUEST_VERSION["CalcImpliedVolatility"] = 2
+}
+
+func (r *CalcImpliedVolatilityRequest) Send(b *MarketDataBroker) {
+	b.setContract(r.Rid, r.Contract)
+	b.WriteInt(REQUEST_CODE["CalcImpliedVolatility"])
+	b.WriteInt(REQUEST_VERSION["CalcImpliedVolatility"])
+	b.WriteInt(r.Rid)
+	b.writeOptionContract(&r.Contract)
+	b.WriteFloat(r.OptionPrice)
+	b.WriteFloat(r.UnderPrice)
+
+	b.Broker.SendRequest()
+}
+
+type CancelCalcImpliedVolatilityRequest struct {
+	Rid int64
+}
+
+func init() {
+	REQUEST_CODE["CancelCalcImpliedVolatility"] = 56
+	REQUEST_VERSION["CancelCalcImpliedVolatility"] = 1
+}
+
+func (r *CancelCalcImpliedVolatilityRequest) Send(b *MarketDataBroker) {
+	b.WriteInt(REQUEST_CODE["CancelCalcImpliedVolatility"])
+	b.WriteInt(REQUEST_VERSION["CancelCalcImpliedVolatility"])
+	b.WriteInt(r.Rid)
+
+	b.Broker.SendRequest()
+
+	b.forgetContract(r.Rid)
+}
+
+// CalcOptionPriceRequest asks the gateway for the price and greeks of an
+// option at the given volatility and underlying price.
+type CalcOptionPriceRequest struct {
+	Rid        int64
+	Contract   Contract
+	Volatility float64
+	UnderPrice float64
+}
+
+func init() {
+	REQUEST_CODE["CalcOptionPrice"] = 55
+	REQUEST_VERSION["CalcOptionPrice"] = 2
+}
+
+func (r *CalcOptionPriceRequest) Send(b *MarketDataBroker) {
+	b.setContract(r.Rid, r.Contract)
+	b.WriteInt(REQUEST_CODE["CalcOptionPrice"])
+	b.WriteInt(REQUEST_VERSION["CalcOptionPrice"])
+	b.WriteInt(r.Rid)
+	b.writeOptionContract(&r.Contract)
+	b.WriteFloat(r.Volatility)
+	b.WriteFloat(r.UnderPrice)
+
+	b.Broker.SendRequest()
+}
+
+type CancelCalcOptionPriceRequest struct {
+	Rid int64
+}
+
+func init() {
+	REQUEST_CODE["CancelCalcOptionPrice"] = 57
+	REQUEST_VERSION["CancelCalcOptionPrice"] = 1
+}
+
+func (r *CancelCalcOptionPriceRequest) Send(b *MarketDataBroker) {
+	b.WriteInt(REQUEST_CODE["CancelCalcOptionPrice"])
+	b.WriteInt(REQUEST_VERSION["CancelCalcOptionPrice"])
+	b.WriteInt(r.Rid)
+
+	b.Broker.SendRequest()
+
+	b.forgetContract(r.Rid)
+}
+
+func (b *MarketDataBroker) writeOptionContract(c *Contract) {
+	b.WriteInt(c.ContractId)
+	b.WriteString(c.Symbol)
+	b.WriteString(c.SecurityType)
+	b.WriteString(c.Expiry)
+	b.WriteFloat(c.Strike)
+	b.WriteString(c.Right)
+	b.WriteString(c.Multiplier)
+	b.WriteString(c.Exchange)
+	b.WriteString(c.PrimaryExchange)
+	b.WriteString(c.Currency)
+	b.WriteString(c.LocalSymbol)
+	b.WriteString(c.TradingClass)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// CALCULATIONS
+////////////////////////////////////////////////////////////////////////////////
+
+type optionCalcWaiter struct {
+	result TickOptComp
+	err    error
+	done   chan struct{}
+}
+
+// CalculateImpliedVolatility returns the implied volatility and greeks of
+// option c priced at optionPrice with the underlying at underPrice. Listen
+// must be running.
+func (b *MarketDataBroker) CalculateImpliedVolatility(ctx context.Context, c Contract, optionPrice, underPrice float64) (TickOptComp, error) {
+	id, w := b.registerCalc()
+
+	r := CalcImpliedVolatilityRequest{id, c, optionPrice, underPrice}
+	r.Send(b)
+
+	res, err := b.waitCalc(ctx, w)
+
+	cancel := CancelCalcImpliedVolatilityRequest{id}
+	cancel.Send(b)
+	b.releaseCalc(id)
+
+	return res, err
+}
+
+// CalculateOptionPrice returns the price and greeks of option c at volatility
+// vol (annualised, 0.25 for 25%) with the underlying at underPrice. Listen
+// must be running.
+func (b *MarketDataBroker) CalculateOptionPrice(ctx context.Context, c Contract, vol, underPrice float64) (TickOptComp, error) {
+	id, w := b.registerCalc()
+
+	r := CalcOptionPriceRequest{id, c, vol, underPrice}
+	r.Send(b)
+
+	res, err := b.waitCalc(ctx, w)
+
+	cancel := CancelCalcOptionPriceRequest{id}
+	cancel.Send(b)
+	b.releaseCalc(id)
+
+	return res, err
+}
+
+func (b *MarketDataBroker) registerCalc() (int64, *optionCalcWaiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.NextReqId()
+	w := &optionCalcWaiter{done: make(chan struct{})}
+	b.calcs[id] = w
+
+	return id, w
+}
+
+func (b *MarketDataBroker) waitCalc(ctx context.Context, w *optionCalcWaiter) (TickOptComp, error) {
+	select {
+	case <-w.done:
+		return w.result, w.err
+	case <-ctx.Done():
+		return TickOptComp{}, ctx.Err()
+	}
+}
+
+// releaseCalc forgets a calculation once its cancel has been sent. Results
+// still in flight are discarded rather than delivered on TickOptCompChan.
+func (b *MarketDataBroker) releaseCalc(id int64) {
+	b.discarded.Add(id)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.calcs, id)
+}
+
+// completeCalc hands a computation to the pending calculation for its request
+// and reports whether there was one. Later results for the request are
+// discarded.
+func (b *MarketDataBroker) completeCalc(r TickOptComp) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w, ok := b.calcs[r.Rid]
+
+	if !ok {
+		return false
+	}
+
+	b.discarded.Add(r.Rid)
+	delete(b.calcs, r.Rid)
+
+	w.result = r
+	close(w.done)
+
+	return true
+}
+
+func (b *MarketDataBroker) failCalc(id, code int64, msg string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w, ok := b.calcs[id]
+
+	if !ok {
+		return false
+	}
+
+	b.discarded.Add(id)
+	delete(b.calcs, id)
+
+	w.err = fmt.Errorf("option calculation %d: error %d: %s", id, code, msg)
+	close(w.done)
+
+	return true
+}
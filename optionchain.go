@@ -0,0 +1,308 @@
+package ib
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// OPTION CHAINS
+////////////////////////////////////////////////////////////////////////////////
+
+type OptionChain struct {
+	Underlying Contract
+	Expiries   []OptionExpiry // sorted by expiry, trading class and multiplier
+}
+
+// OptionExpiry holds the strikes of one expiry of one trading class and
+// multiplier. An expiry listed by several classes, such as SPX and SPXW or an
+// adjusted series next to the standard one, has an OptionExpiry per class.
+type OptionExpiry struct {
+	Expiry       string
+	Date         time.Time
+	TradingClass string
+	Multiplier   float64
+	Strikes      []OptionStrike // sorted by strike
+}
+
+type OptionStrike struct {
+	Strike float64
+	Call   *OptionContract
+	Put    *OptionContract
+}
+
+type OptionContract struct {
+	ContractId   int64
+	Symbol       string
+	SecurityType string
+	LocalSymbol  string
+	Expiry       string
+	Strike       float64
+	Right        string
+	Multiplier   float64
+	TradingClass string
+	Exchange     string
+	Currency     string
+}
+
+// OptionChainFilter narrows the contracts kept in a chain. Zero values do not
+// filter. Moneyness is a fraction of UnderlyingPrice, so 0.1 keeps strikes
+// within 10% of the underlying.
+type OptionChainFilter struct {
+	MinExpiry       time.Time
+	MaxExpiry       time.Time
+	UnderlyingPrice float64
+	Moneyness       float64
+	Exchange        string
+	TradingClass    string
+}
+
+func (o *OptionContract) Contract() Contract {
+	return Contract{
+		ContractId:   o.ContractId,
+		Symbol:       o.Symbol,
+		SecurityType: o.SecurityType,
+		Expiry:       o.Expiry,
+		Strike:       o.Strike,
+		Right:        o.Right,
+		Multiplier:   formatMultiplier(o.Multiplier),
+		Exchange:     o.Exchange,
+		Currency:     o.Currency,
+		LocalSymbol:  o.LocalSymbol,
+		TradingClass: o.TradingClass,
+	}
+}
+
+func formatMultiplier(m float64) string {
+	if m == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(m, 'f', -1, 64)
+}
+
+// OptionChain requests every option on the underlying with a wildcard
+// contract details request (blank expiry, strike and right) and arranges the
+// results by expiry and strike. Options on futures are requested as FOP.
+func (b *ContractDetailsBroker) OptionChain(ctx context.Context, underlying Contract, f OptionChainFilter) (*OptionChain, error) {
+	c := Contract{
+		Symbol:       underlying.Symbol,
+		SecurityType: "OPT",
+		Exchange:     f.Exchange,
+		Currency:     underlying.Currency,
+		TradingClass: f.TradingClass,
+	}
+
+	if underlying.SecurityType == "FUT" {
+		c.SecurityType = "FOP"
+
+		if c.Exchange == "" {
+			c.Exchange = underlying.Exchange
+		}
+	}
+
+	if c.Exchange == "" {
+		c.Exchange = "SMART"
+	}
+
+	d, err := b.Fetch(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOptionChain(underlying, d, f), nil
+}
+
+type optionExpiryKey struct {
+	Expiry       string
+	TradingClass string
+	Multiplier   float64
+}
+
+// NewOptionChain builds a chain from the contract details of its options,
+// grouping them by expiry, trading class and multiplier so that options of
+// different classes at the same strike do not replace each other.
+func NewOptionChain(underlying Contract, d []ContractDetails, f OptionChainFilter) *OptionChain {
+	expiries := make(map[optionExpiryKey]*OptionExpiry)
+
+	for i := range d {
+		if !f.keep(&d[i]) {
+			continue
+		}
+
+		k := optionExpiryKey{d[i].Expiry, d[i].TradingClass, d[i].Multiplier}
+		e, ok := expiries[k]
+
+		if !ok {
+			e = &OptionExpiry{
+				Expiry:       d[i].Expiry,
+				Date:         parseExpiry(d[i].Expiry),
+				TradingClass: d[i].TradingClass,
+				Multiplier:   d[i].Multiplier,
+			}
+			expiries[k] = e
+		}
+
+		o := &OptionContract{
+			ContractId:   d[i].ContractId,
+			Symbol:       d[i].Symbol,
+			SecurityType: d[i].SecurityType,
+			LocalSymbol:  d[i].LocalSymbol,
+			Expiry:       d[i].Expiry,
+			Strike:       d[i].Strike,
+			Right:        d[i].Right,
+			Multiplier:   d[i].Multiplier,
+			TradingClass: d[i].TradingClass,
+			Exchange:     d[i].Exchange,
+			Currency:     d[i].Currency,
+		}
+
+		j := sort.Search(len(e.Strikes), func(j int) bool { return e.Strikes[j].Strike >= o.Strike })
+
+		if j == len(e.Strikes) || e.Strikes[j].Strike != o.Strike {
+			e.Strikes = append(e.Strikes, OptionStrike{})
+			copy(e.Strikes[j+1:], e.Strikes[j:])
+			e.Strikes[j] = OptionStrike{Strike: o.Strike}
+		}
+
+		switch strings.ToUpper(o.Right) {
+		case "C", "CALL":
+			e.Strikes[j].Call = o
+		case "P", "PUT":
+			e.Strikes[j].Put = o
+		}
+	}
+
+	r := &OptionChain{Underlying: underlying}
+
+	for _, e := range expiries {
+		r.Expiries = append(r.Expiries, *e)
+	}
+
+	sort.Slice(r.Expiries, func(i, j int) bool {
+		a, b := &r.Expiries[i], &r.Expiries[j]
+
+		if a.Expiry != b.Expiry {
+			return a.Expiry < b.Expiry
+		}
+
+		if a.TradingClass != b.TradingClass {
+			return a.TradingClass < b.TradingClass
+		}
+
+		return a.Multiplier < b.Multiplier
+	})
+
+	return r
+}
+
+func (f *OptionChainFilter) keep(d *ContractDetails) bool {
+	if f.Exchange != "" && d.Exchange != f.Exchange {
+		return false
+	}
+
+	if f.TradingClass != "" && d.TradingClass != f.TradingClass {
+		return false
+	}
+
+	if !f.MinExpiry.IsZero() || !f.MaxExpiry.IsZero() {
+		t := parseExpiry(d.Expiry)
+
+		if t.IsZero() {
+			return false
+		}
+
+		if !f.MinExpiry.IsZero() && t.Before(f.MinExpiry) {
+			return false
+		}
+
+		if !f.MaxExpiry.IsZero() && t.After(f.MaxExpiry) {
+			return false
+		}
+	}
+
+	if f.Moneyness > 0 && f.UnderlyingPrice > 0 {
+		if math.Abs(d.Strike-f.UnderlyingPrice) > f.Moneyness*f.UnderlyingPrice {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseExpiry parses the YYYYMMDD or YYYYMM part of an expiry.
+func parseExpiry(s string) time.Time {
+	if len(s) >= 8 {
+		if t, err := time.Parse("20060102", s[:8]); err == nil {
+			return t
+		}
+	}
+
+	if len(s) >= 6 {
+		if t, err := time.Parse("200601", s[:6]); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Expiry returns the strikes listed for an expiry by a trading class. A blank
+// trading class matches the first class listing the expiry, which is only
+// unambiguous for chains built with OptionChainFilter.TradingClass.
+func (c *OptionChain) Expiry(expiry, tradingClass string) (*OptionExpiry, bool) {
+	for i := range c.Expiries {
+		e := &c.Expiries[i]
+
+		if e.Expiry == expiry && (tradingClass == "" || e.TradingClass == tradingClass) {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Options returns the contracts for an expiry, strike and right ("C" or "P"),
+// one per trading class and multiplier listing them.
+func (c *OptionChain) Options(expiry string, strike float64, right string) []*OptionContract {
+	var r []*OptionContract
+
+	for i := range c.Expiries {
+		if c.Expiries[i].Expiry != expiry {
+			continue
+		}
+
+		if o, ok := c.Expiries[i].Option(strike, right); ok {
+			r = append(r, o)
+		}
+	}
+
+	return r
+}
+
+// Option returns the contract for a strike and right ("C" or "P").
+func (e *OptionExpiry) Option(strike float64, right string) (*OptionContract, bool) {
+	for _, s := range e.Strikes {
+		if s.Strike != strike {
+			continue
+		}
+
+		var o *OptionContract
+
+		switch strings.ToUpper(right) {
+		case "C", "CALL":
+			o = s.Call
+		case "P", "PUT":
+			o = s.Put
+		}
+
+		return o, o != nil
+	}
+
+	return nil, false
+}
@@ -1,6 +1,9 @@
 package ib
 
-import "log"
+import (
+	"fmt"
+	"log"
+)
 
 type Order struct {
 	OrderID                       int64
@@ -90,6 +93,10 @@ func init() {
 }
 
 func (r *PlaceOrderRequest) Send(id int64, b *OrderBroker) {
+	if b.TickRules != nil {
+		r.round(id, b)
+	}
+
 	b.WriteInt(REQUEST_CODE["PlaceOrder"])
 	b.WriteInt(REQUEST_VERSION["PlaceOrder"])
 	b.WriteInt(id)
@@ -216,6 +223,15 @@ func (r *PlaceOrderRequest) Send(id int64, b *OrderBroker) {
 	b.Broker.SendRequest()
 }
 
+// round rounds the prices of the order to the tick rules of its contract.
+func (r *PlaceOrderRequest) round(id int64, b *OrderBroker) {
+	if d, err := b.TickRules.Get(&r.Contract); err == nil {
+		RoundOrderPrices(&r.Order, &d, b.Rounding)
+	} else if err == ErrNoContractId && b.TickRules.Len() > 0 {
+		Log.Print("error", fmt.Errorf("order %d: prices not rounded: %v", id, err))
+	}
+}
+
 type CancelOrderRequest struct {
 	Rid int64
 }
@@ -315,6 +331,8 @@ type OrderBroker struct {
 	OrderStatusChan chan OrderStatus
 	OpenOrderChan   chan OpenOrder
 	NextValidIdChan chan NextValidId
+	TickRules       *TickRules   // prices of orders for these contracts are rounded before sending; nil skips rounding
+	Rounding        RoundingMode // defaults to RoundPassive
 }
 
 func NewOrderBroker() OrderBroker {
@@ -323,6 +341,8 @@ func NewOrderBroker() OrderBroker {
 		make(chan OrderStatus),
 		make(chan OpenOrder),
 		make(chan NextValidId),
+		NewTickRules(),
+		RoundPassive,
 	}
 
 	return b
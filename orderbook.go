@@ -0,0 +1,484 @@
+package ib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// ORDER BOOK
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	DepthAsk int64 = 0
+	DepthBid int64 = 1
+)
+
+const (
+	DepthInsert int64 = 0
+	DepthUpdate int64 = 1
+	DepthDelete int64 = 2
+)
+
+type BookLevel struct {
+	Price float64
+	Size  int64
+}
+
+// BookSnapshot is a copy of an order book. Level 0 is the best bid and ask.
+type BookSnapshot struct {
+	Rid      int64
+	Contract Contract
+	Bids     []BookLevel
+	Asks     []BookLevel
+	Updated  time.Time
+}
+
+// ErrBookStale is returned when a book is read while the gateway is still
+// sending it after a (re)subscription.
+var ErrBookStale = errors.New("order book is being resent by the gateway")
+
+// OrderBook rebuilds the book of a market depth subscription from the
+// insert, update and delete operations the gateway sends by position.
+//
+// A book that OrderBooks (re)subscribes is stale until the gateway has sent
+// it in full, which is taken to be when both sides hold the requested number
+// of rows or the first update or delete arrives, since the initial book is
+// sent as inserts only. Reads of a stale book fail.
+type OrderBook struct {
+	Contract Contract
+	rid      int64
+	rows     int64
+	stale    bool
+	bids     []BookLevel
+	asks     []BookLevel
+	updated  time.Time
+	mu       sync.RWMutex
+}
+
+func NewOrderBook(rid int64, c Contract) *OrderBook {
+	return &OrderBook{Contract: c, rid: rid}
+}
+
+// Rid returns the market depth request of the book, which changes when
+// OrderBooks resubscribes it.
+func (o *OrderBook) Rid() int64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.rid
+}
+
+func (o *OrderBook) Stale() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.stale
+}
+
+// restart empties the book and marks it stale until the gateway has sent the
+// rows of request rid.
+func (o *OrderBook) restart(rid, rows int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.rid = rid
+	o.rows = rows
+	o.stale = true
+	o.bids, o.asks = nil, nil
+	o.updated = time.Now()
+}
+
+// Apply applies one depth operation. An operation that does not fit the
+// book, such as an update of a missing level, leaves the book unchanged and
+// returns an error; the book is then out of sync with the gateway.
+func (o *OrderBook) Apply(operation, side, position int64, price float64, size int64) error {
+	_, err := o.apply(operation, side, position, price, size)
+	return err
+}
+
+// apply is Apply that also returns the level an update or delete replaced.
+func (o *OrderBook) apply(operation, side, position int64, price float64, size int64) (BookLevel, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var prev BookLevel
+
+	var l *[]BookLevel
+
+	switch side {
+	case DepthBid:
+		l = &o.bids
+	case DepthAsk:
+		l = &o.asks
+	default:
+		return prev, fmt.Errorf("order book %d: invalid side %d", o.rid, side)
+	}
+
+	n := int64(len(*l))
+
+	switch operation {
+	case DepthInsert:
+		if position < 0 || position > n {
+			return prev, fmt.Errorf("order book %d: insert at position %d of %d levels", o.rid, position, n)
+		}
+
+		*l = append(*l, BookLevel{})
+		copy((*l)[position+1:], (*l)[position:])
+		(*l)[position] = BookLevel{price, size}
+	case DepthUpdate:
+		if position < 0 || position >= n {
+			return prev, fmt.Errorf("order book %d: update of position %d of %d levels", o.rid, position, n)
+		}
+
+		prev = (*l)[position]
+		(*l)[position] = BookLevel{price, size}
+	case DepthDelete:
+		if position < 0 || position >= n {
+			return prev, fmt.Errorf("order book %d: delete of position %d of %d levels", o.rid, position, n)
+		}
+
+		prev = (*l)[position]
+		*l = append((*l)[:position], (*l)[position+1:]...)
+	default:
+		return prev, fmt.Errorf("order book %d: invalid operation %d", o.rid, operation)
+	}
+
+	o.updated = time.Now()
+
+	if o.stale && (operation != DepthInsert || o.rows > 0 && int64(len(o.bids)) >= o.rows && int64(len(o.asks)) >= o.rows) {
+		o.stale = false
+	}
+
+	return prev, nil
+}
+
+func (o *OrderBook) ApplyDepth(d *MarketDepth) error {
+	return o.Apply(d.Operation, d.Side, d.Position, d.Price, d.Size)
+}
+
+// Reset empties the book.
+func (o *OrderBook) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.bids, o.asks = nil, nil
+	o.updated = time.Now()
+}
+
+// Snapshot returns a copy of the book. A stale book returns an empty
+// snapshot and ErrBookStale.
+func (o *OrderBook) Snapshot() (BookSnapshot, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	s := BookSnapshot{
+		Rid:      o.rid,
+		Contract: o.Contract,
+		Updated:  o.updated,
+	}
+
+	if o.stale {
+		return s, ErrBookStale
+	}
+
+	s.Bids = append([]BookLevel(nil), o.bids...)
+	s.Asks = append([]BookLevel(nil), o.asks...)
+
+	return s, nil
+}
+
+// BestBid returns the top bid level, if any and the book is not stale.
+func (o *OrderBook) BestBid() (BookLevel, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.stale || len(o.bids) == 0 {
+		return BookLevel{}, false
+	}
+
+	return o.bids[0], true
+}
+
+func (o *OrderBook) BestAsk() (BookLevel, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.stale || len(o.asks) == 0 {
+		return BookLevel{}, false
+	}
+
+	return o.asks[0], true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ORDER BOOKS
+////////////////////////////////////////////////////////////////////////////////
+
+// BookChange reports an order book after a depth operation. Level is the
+// level an insert or update wrote and Previous the one an update or delete
+// replaced. Book is empty while Stale is set. Reset is set when the book was
+// emptied and resubscribed under a new request id, in which case OldRid is
+// the request it replaced and Rid the new one.
+type BookChange struct {
+	Rid       int64
+	Book      BookSnapshot
+	Operation int64
+	Side      int64
+	Position  int64
+	Level     BookLevel
+	Previous  BookLevel
+	Stale     bool
+	Reset     bool
+	OldRid    int64
+}
+
+// BookChangeHandler receives every change of the books of an OrderBooks.
+type BookChangeHandler func(c BookChange)
+
+// OrderBooks keeps an OrderBook per market depth subscription of a broker.
+// When a book detects an inconsistent update the subscription is cancelled
+// and sent again under a new request id, so that updates still in flight for
+// the old one are ignored, and the book is stale until the gateway has resent
+// it. Every change is handed to the registered handlers and, when ChangeChan
+// is not nil, sent on it and must be received.
+type OrderBooks struct {
+	Broker     *MarketDepthBroker
+	ChangeChan chan BookChange
+	books      map[int64]*OrderBook
+	levelTwo   map[int64]*LevelTwoBook
+	rows       map[int64]int64
+	handlers   []BookChangeHandler
+	mu         sync.Mutex
+}
+
+func NewOrderBooks(b *MarketDepthBroker, notify bool) *OrderBooks {
+	o := &OrderBooks{
+		Broker:   b,
+		books:    make(map[int64]*OrderBook),
+		levelTwo: make(map[int64]*LevelTwoBook),
+		rows:     make(map[int64]int64),
+	}
+
+	if notify {
+		o.ChangeChan = make(chan BookChange)
+	}
+
+	return o
+}
+
+// Subscribe requests numRows levels of depth for c and returns its book,
+// which is stale until the gateway has sent it.
+func (o *OrderBooks) Subscribe(c Contract, numRows int64) *OrderBook {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id := o.Broker.NextReqId()
+	book := NewOrderBook(id, c)
+	book.restart(id, numRows)
+	o.books[id] = book
+	o.rows[id] = numRows
+
+	r := MarketDepthRequest{id, c, numRows}
+	r.Send(o.Broker)
+
+	return book
+}
+
+// Register adds a handler for book changes. Handlers run on the goroutine
+// applying the updates, before the change is sent on ChangeChan.
+func (o *OrderBooks) Register(h BookChangeHandler) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.handlers = append(o.handlers, h)
+}
+
+func (o *OrderBooks) Unsubscribe(book *OrderBook) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rid := book.Rid()
+
+	if o.books[rid] != book {
+		return
+	}
+
+	delete(o.books, rid)
+	delete(o.levelTwo, rid)
+	delete(o.rows, rid)
+
+	r := CancelMarketDepthRequest{rid}
+	r.Send(o.Broker)
+}
+
+// Book returns the book of a current request id.
+func (o *OrderBooks) Book(rid int64) (*OrderBook, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	b, ok := o.books[rid]
+
+	return b, ok
+}
+
+// LevelTwo returns the per market maker book of rid, which exists once a
+// level two update has been received.
+func (o *OrderBooks) LevelTwo(rid int64) (*LevelTwoBook, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	b, ok := o.levelTwo[rid]
+
+	return b, ok
+}
+
+// Run consumes the depth channels of the broker and applies every update to
+// its book. Level two updates are also applied by position to the OrderBook,
+// which then holds one level per row. It never returns, so it is normally
+// started in its own goroutine next to Broker.Listen.
+func (o *OrderBooks) Run() {
+	for {
+		select {
+		case r := <-o.Broker.MarketDepthChan:
+			o.Apply(r.Rid, r.Operation, r.Side, r.Position, r.Price, r.Size)
+		case r := <-o.Broker.MarketDepthLevelTwoChan:
+			o.ApplyLevelTwo(&r)
+		}
+	}
+}
+
+// Apply applies a depth operation to the book of rid, resubscribing when the
+// operation shows that the book is out of sync. Operations for request ids
+// that are no longer current are ignored.
+func (o *OrderBooks) Apply(rid, operation, side, position int64, price float64, size int64) {
+	book, ok := o.Book(rid)
+
+	if !ok {
+		return
+	}
+
+	c := BookChange{
+		Operation: operation,
+		Side:      side,
+		Position:  position,
+	}
+
+	prev, err := book.apply(operation, side, position, price, size)
+
+	if err != nil {
+		Log.Print("error", err)
+		c.OldRid, c.Reset = o.resubscribe(book)
+		o.notify(book, c)
+		return
+	}
+
+	c.Previous = prev
+
+	if operation != DepthDelete {
+		c.Level = BookLevel{price, size}
+	}
+
+	o.notify(book, c)
+}
+
+// ApplyLevelTwo applies a level two update to the per market maker book and
+// the OrderBook of its request.
+func (o *OrderBooks) ApplyLevelTwo(d *MarketDepthLevelTwo) {
+	o.mu.Lock()
+
+	book, subscribed := o.books[d.Rid]
+	l2, ok := o.levelTwo[d.Rid]
+
+	if subscribed && !ok {
+		l2 = NewLevelTwoBook(d.Rid, book.Contract)
+		l2.restart(d.Rid, o.rows[d.Rid])
+		o.levelTwo[d.Rid] = l2
+	}
+
+	o.mu.Unlock()
+
+	if !subscribed {
+		return
+	}
+
+	if err := l2.ApplyLevelTwo(d); err != nil {
+		Log.Print("error", err)
+
+		if old, ok := o.resubscribe(book); ok {
+			o.notify(book, BookChange{
+				Operation: d.Operation,
+				Side:      d.Side,
+				Position:  d.Position,
+				Reset:     true,
+				OldRid:    old,
+			})
+		}
+
+		return
+	}
+
+	o.Apply(d.Rid, d.Operation, d.Side, d.Position, d.Price, d.Size)
+}
+
+func (o *OrderBooks) notify(book *OrderBook, c BookChange) {
+	o.mu.Lock()
+	l := o.handlers
+	o.mu.Unlock()
+
+	if len(l) == 0 && o.ChangeChan == nil {
+		return
+	}
+
+	var err error
+
+	c.Rid = book.Rid()
+	c.Book, err = book.Snapshot()
+	c.Stale = err != nil
+
+	for _, h := range l {
+		h(c)
+	}
+
+	if o.ChangeChan != nil {
+		o.ChangeChan <- c
+	}
+}
+
+// resubscribe cancels the request of book and sends it again under a new
+// request id, returning the old one.
+func (o *OrderBooks) resubscribe(book *OrderBook) (int64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	old := book.Rid()
+	rows, ok := o.rows[old]
+
+	if !ok || o.books[old] != book {
+		return 0, false
+	}
+
+	id := o.Broker.NextReqId()
+
+	delete(o.books, old)
+	delete(o.rows, old)
+	o.books[id] = book
+	o.rows[id] = rows
+	book.restart(id, rows)
+
+	if l2, ok := o.levelTwo[old]; ok {
+		delete(o.levelTwo, old)
+		o.levelTwo[id] = l2
+		l2.restart(id, rows)
+	}
+
+	cancel := CancelMarketDepthRequest{old}
+	cancel.Send(o.Broker)
+
+	r := MarketDepthRequest{id, book.Contract, rows}
+	r.Send(o.Broker)
+
+	return old, true
+}
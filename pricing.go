@@ -0,0 +1,418 @@
+package ib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// OPTION PRICING
+////////////////////////////////////////////////////////////////////////////////
+
+type OptionModel int64
+
+const (
+	BlackScholes     OptionModel = iota // European options on stocks and indexes
+	Black76                             // European options on futures
+	AmericanBinomial                    // Cox-Ross-Rubinstein tree with early exercise
+)
+
+// BINOMIAL_STEPS is the depth of the AmericanBinomial tree.
+var BINOMIAL_STEPS = 200
+
+// OptionInputs describes an option for the pricing models. Underlying is the
+// futures price for Black76. Years is the time to expiry, Rate the continuously
+// compounded interest rate, Yield the continuous dividend yield and Volatility
+// the annualised volatility, all as fractions (0.25 for 25%). For options on
+// futures under AmericanBinomial set Yield to Rate.
+type OptionInputs struct {
+	Right      string
+	Underlying float64
+	Strike     float64
+	Years      float64
+	Rate       float64
+	Yield      float64
+	Volatility float64
+}
+
+// Greeks are quoted in the units used by the gateway: Vega per volatility
+// point, Theta per calendar day and Rho per percentage point of Rate.
+type Greeks struct {
+	Price float64
+	Delta float64
+	Gamma float64
+	Vega  float64
+	Theta float64
+	Rho   float64
+}
+
+// NewOptionInputs fills OptionInputs from an option contract, measuring the
+// time to expiry from now to the end of the expiry date.
+func NewOptionInputs(c *Contract, underlying, vol, rate, yield float64, now time.Time) OptionInputs {
+	return OptionInputs{
+		Right:      c.Right,
+		Underlying: underlying,
+		Strike:     c.Strike,
+		Years:      YearsToExpiry(c.Expiry, now),
+		Rate:       rate,
+		Yield:      yield,
+		Volatility: vol,
+	}
+}
+
+// YearsToExpiry returns the time from now to the end of an expiry written as
+// YYYYMMDD, or 0 when it has passed or cannot be parsed.
+func YearsToExpiry(expiry string, now time.Time) float64 {
+	t := parseExpiry(expiry)
+
+	if t.IsZero() {
+		return 0
+	}
+
+	d := t.AddDate(0, 0, 1).Sub(now)
+
+	if d <= 0 {
+		return 0
+	}
+
+	return d.Hours() / 24 / 365
+}
+
+func (m OptionModel) String() string {
+	switch m {
+	case BlackScholes:
+		return "BLACK_SCHOLES"
+	case Black76:
+		return "BLACK_76"
+	case AmericanBinomial:
+		return "AMERICAN_BINOMIAL"
+	default:
+		return fmt.Sprintf("OptionModel(%d)", int64(m))
+	}
+}
+
+func (m OptionModel) Price(in OptionInputs) float64 {
+	return m.Greeks(in).Price
+}
+
+func (m OptionModel) Greeks(in OptionInputs) Greeks {
+	call := normalizeRight(in.Right) != "P"
+
+	if in.Years <= 0 || in.Volatility <= 0 || in.Underlying <= 0 || in.Strike <= 0 {
+		return expiredGreeks(in, call)
+	}
+
+	switch m {
+	case Black76:
+		in.Yield = in.Rate
+		g := blackScholes(in, call)
+		// the futures price does not move with the rate
+		g.Rho = -in.Years * g.Price / 100
+		return g
+	case AmericanBinomial:
+		return binomialGreeks(in, call)
+	default:
+		return blackScholes(in, call)
+	}
+}
+
+func normCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func normPDF(x float64) float64 {
+	return math.Exp(-x*x/2) / math.Sqrt(2*math.Pi)
+}
+
+// expiredGreeks values an option without time value: the intrinsic value of
+// the forward, discounted, which is also the zero volatility limit.
+func expiredGreeks(in OptionInputs, call bool) Greeks {
+	var g Greeks
+
+	t := math.Max(in.Years, 0)
+	fwd := in.Underlying * math.Exp(-in.Yield*t)
+	k := in.Strike * math.Exp(-in.Rate*t)
+
+	if call && fwd > k {
+		g.Price = fwd - k
+		g.Delta = math.Exp(-in.Yield * t)
+	} else if !call && k > fwd {
+		g.Price = k - fwd
+		g.Delta = -math.Exp(-in.Yield * t)
+	}
+
+	return g
+}
+
+func blackScholes(in OptionInputs, call bool) Greeks {
+	var g Greeks
+
+	s, k, t, r, q, v := in.Underlying, in.Strike, in.Years, in.Rate, in.Yield, in.Volatility
+
+	sqrtT := math.Sqrt(t)
+	d1 := (math.Log(s/k) + (r-q+v*v/2)*t) / (v * sqrtT)
+	d2 := d1 - v*sqrtT
+	dq := math.Exp(-q * t)
+	dr := math.Exp(-r * t)
+
+	g.Gamma = dq * normPDF(d1) / (s * v * sqrtT)
+	g.Vega = s * dq * normPDF(d1) * sqrtT / 100
+	decay := -s * dq * normPDF(d1) * v / (2 * sqrtT)
+
+	if call {
+		g.Price = s*dq*normCDF(d1) - k*dr*normCDF(d2)
+		g.Delta = dq * normCDF(d1)
+		g.Theta = (decay - r*k*dr*normCDF(d2) + q*s*dq*normCDF(d1)) / 365
+		g.Rho = k * t * dr * normCDF(d2) / 100
+	} else {
+		g.Price = k*dr*normCDF(-d2) - s*dq*normCDF(-d1)
+		g.Delta = dq * (normCDF(d1) - 1)
+		g.Theta = (decay + r*k*dr*normCDF(-d2) - q*s*dq*normCDF(-d1)) / 365
+		g.Rho = -k * t * dr * normCDF(-d2) / 100
+	}
+
+	return g
+}
+
+// binomialGreeks prices an American option on a Cox-Ross-Rubinstein tree.
+// Delta, gamma and theta are read from the first steps of the tree; vega and
+// rho are taken from repriced trees.
+func binomialGreeks(in OptionInputs, call bool) Greeks {
+	g := binomialTree(in, call)
+
+	const dv = 0.001
+	const dr = 0.0001
+
+	up, down := in, in
+	up.Volatility += dv
+	down.Volatility = math.Max(in.Volatility-dv, 1e-6)
+	g.Vega = (binomialTree(up, call).Price - binomialTree(down, call).Price) / (up.Volatility - down.Volatility) / 100
+
+	up, down = in, in
+	up.Rate += dr
+	down.Rate -= dr
+	g.Rho = (binomialTree(up, call).Price - binomialTree(down, call).Price) / (2 * dr) / 100
+
+	return g
+}
+
+func binomialTree(in OptionInputs, call bool) Greeks {
+	var g Greeks
+
+	n := BINOMIAL_STEPS
+
+	if n < 3 {
+		n = 3
+	}
+
+	s, k := in.Underlying, in.Strike
+	dt := in.Years / float64(n)
+	u := math.Exp(in.Volatility * math.Sqrt(dt))
+	d := 1 / u
+	p := (math.Exp((in.Rate-in.Yield)*dt) - d) / (u - d)
+	disc := math.Exp(-in.Rate * dt)
+
+	exercise := func(price float64) float64 {
+		if call {
+			return math.Max(price-k, 0)
+		}
+		return math.Max(k-price, 0)
+	}
+
+	v := make([]float64, n+1)
+
+	for i := 0; i <= n; i++ {
+		v[i] = exercise(s * math.Pow(u, float64(2*i-n)))
+	}
+
+	var step1, step2 [3]float64
+
+	for j := n - 1; j >= 0; j-- {
+		for i := 0; i <= j; i++ {
+			hold := disc * (p*v[i+1] + (1-p)*v[i])
+			v[i] = math.Max(hold, exercise(s*math.Pow(u, float64(2*i-j))))
+		}
+
+		switch j {
+		case 2:
+			copy(step2[:], v[:3])
+		case 1:
+			copy(step1[:2], v[:2])
+		}
+	}
+
+	g.Price = v[0]
+	g.Delta = (step1[1] - step1[0]) / (s*u - s*d)
+
+	up := (step2[2] - step2[1]) / (s*u*u - s)
+	down := (step2[1] - step2[0]) / (s - s*d*d)
+	g.Gamma = (up - down) / (0.5 * (s*u*u - s*d*d))
+	g.Theta = (step2[1] - v[0]) / (2 * dt) / 365
+
+	return g
+}
+
+var ErrNoImpliedVol = errors.New("no volatility matches the option price")
+
+// ImpliedVol returns the volatility at which the model prices the option at
+// price. The Volatility of in is ignored.
+func (m OptionModel) ImpliedVol(in OptionInputs, price float64) (float64, error) {
+	const (
+		tolerance = 1e-8
+		maxIter   = 100
+	)
+
+	if in.Years <= 0 || price <= 0 {
+		return 0, ErrNoImpliedVol
+	}
+
+	lo, hi := 1e-6, 10.0
+
+	f := func(v float64) Greeks {
+		in.Volatility = v
+		return m.Greeks(in)
+	}
+
+	if price <= f(lo).Price || price >= f(hi).Price {
+		return 0, ErrNoImpliedVol
+	}
+
+	// Newton steps, falling back to bisection when a step leaves the bracket
+	v := 0.3
+
+	for i := 0; i < maxIter; i++ {
+		g := f(v)
+		diff := g.Price - price
+
+		if math.Abs(diff) < tolerance {
+			return v, nil
+		}
+
+		if diff > 0 {
+			hi = v
+		} else {
+			lo = v
+		}
+
+		next := v - diff/(g.Vega*100)
+
+		if g.Vega <= 0 || math.IsNaN(next) || next <= lo || next >= hi {
+			next = (lo + hi) / 2
+		}
+
+		if hi-lo < 1e-10 {
+			return next, nil
+		}
+
+		v = next
+	}
+
+	return v, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GATEWAY COMPUTATIONS
+////////////////////////////////////////////////////////////////////////////////
+
+// Greeks returns the values of an option computation. Values the gateway did
+// not send keep their sentinels.
+func (t *TickOptComp) Greeks() Greeks {
+	return Greeks{
+		Price: t.OptionPrice,
+		Delta: t.Delta,
+		Gamma: t.Gamma,
+		Vega:  t.Vega,
+		Theta: t.Theta,
+	}
+}
+
+// Fill replaces the sentinels of an option computation with values from model
+// m. The implied volatility is solved from OptionPrice when it is missing, and
+// in.Underlying defaults to UndPrice. Values sent by the gateway are kept.
+func (t *TickOptComp) Fill(m OptionModel, in OptionInputs) error {
+	if in.Underlying <= 0 {
+		in.Underlying = t.UndPrice
+	}
+
+	if in.Underlying <= 0 {
+		return fmt.Errorf("option computation %d: no underlying price", t.Rid)
+	}
+
+	if t.UndPrice < 0 {
+		t.UndPrice = in.Underlying
+	}
+
+	if t.ImpliedVol < 0 {
+		if t.OptionPrice < 0 {
+			return fmt.Errorf("option computation %d: no volatility or option price", t.Rid)
+		}
+
+		v, err := m.ImpliedVol(in, t.OptionPrice)
+
+		if err != nil {
+			return fmt.Errorf("option computation %d: %v", t.Rid, err)
+		}
+
+		t.ImpliedVol = v
+	}
+
+	in.Volatility = t.ImpliedVol
+	g := m.Greeks(in)
+
+	if t.OptionPrice < 0 {
+		t.OptionPrice = g.Price
+	}
+
+	if t.Delta == -2 {
+		t.Delta = g.Delta
+	}
+
+	if t.Gamma == -2 {
+		t.Gamma = g.Gamma
+	}
+
+	if t.Vega == -2 {
+		t.Vega = g.Vega
+	}
+
+	if t.Theta == -2 {
+		t.Theta = g.Theta
+	}
+
+	return nil
+}
+
+// Deviation compares an option computation with model m at the gateway's
+// implied volatility and returns the gateway value minus the model value for
+// each greek. Greeks the gateway did not send are reported as 0.
+func (t *TickOptComp) Deviation(m OptionModel, in OptionInputs) (Greeks, error) {
+	var d Greeks
+
+	if t.ImpliedVol < 0 {
+		return d, fmt.Errorf("option computation %d: no implied volatility", t.Rid)
+	}
+
+	if in.Underlying <= 0 {
+		in.Underlying = t.UndPrice
+	}
+
+	in.Volatility = t.ImpliedVol
+	g := m.Greeks(in)
+
+	diff := func(ib, model, sentinel float64) float64 {
+		if ib == sentinel {
+			return 0
+		}
+		return ib - model
+	}
+
+	d.Price = diff(t.OptionPrice, g.Price, -1)
+	d.Delta = diff(t.Delta, g.Delta, -2)
+	d.Gamma = diff(t.Gamma, g.Gamma, -2)
+	d.Vega = diff(t.Vega, g.Vega, -2)
+	d.Theta = diff(t.Theta, g.Theta, -2)
+
+	return d, nil
+}
@@ -0,0 +1,162 @@
+package ib
+
+import (
+	"math"
+	"testing"
+)
+
+func option(right string, s, k, t, r, q, v float64) OptionInputs {
+	return OptionInputs{Right: right, Underlying: s, Strike: k, Years: t, Rate: r, Yield: q, Volatility: v}
+}
+
+func TestBlackScholesPrice(t *testing.T) {
+	tests := []struct {
+		in   OptionInputs
+		want float64
+	}{
+		{option("C", 100, 100, 1, 0.05, 0, 0.2), 10.4506},
+		{option("P", 100, 100, 1, 0.05, 0, 0.2), 5.5735},
+		{option("C", 42, 40, 0.5, 0.1, 0, 0.2), 4.7594},
+		{option("P", 42, 40, 0.5, 0.1, 0, 0.2), 0.8086},
+		{option("C", 100, 110, 0, 0.05, 0, 0.2), 0},
+		{option("P", 100, 110, 0, 0.05, 0, 0.2), 10},
+	}
+
+	for _, tt := range tests {
+		if got := BlackScholes.Price(tt.in); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("BlackScholes.Price(%+v) = %.4f, want %.4f", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPutCallParity(t *testing.T) {
+	tests := []struct {
+		model OptionModel
+		in    OptionInputs
+	}{
+		{BlackScholes, option("", 100, 100, 1, 0.05, 0, 0.2)},
+		{BlackScholes, option("", 100, 80, 0.25, 0.01, 0.03, 0.35)},
+		{BlackScholes, option("", 50, 75, 2, 0.08, 0.02, 0.6)},
+		{BlackScholes, option("", 100, 100, 1.0/365, 0.05, 0, 0.15)},
+		{Black76, option("", 2000, 2050, 0.5, 0.02, 0, 0.18)},
+		{Black76, option("", 60, 45, 1, 0.04, 0, 0.4)},
+	}
+
+	for _, tt := range tests {
+		call, put := tt.in, tt.in
+		call.Right, put.Right = "C", "P"
+
+		c := tt.model.Greeks(call)
+		p := tt.model.Greeks(put)
+
+		q := tt.in.Yield
+
+		if tt.model == Black76 {
+			q = tt.in.Rate
+		}
+
+		fwd := tt.in.Underlying*math.Exp(-q*tt.in.Years) - tt.in.Strike*math.Exp(-tt.in.Rate*tt.in.Years)
+
+		if diff := c.Price - p.Price - fwd; math.Abs(diff) > 1e-9 {
+			t.Errorf("%v %+v: call - put = %.10f, want %.10f", tt.model, tt.in, c.Price-p.Price, fwd)
+		}
+
+		if diff := c.Delta - p.Delta - math.Exp(-q*tt.in.Years); math.Abs(diff) > 1e-9 {
+			t.Errorf("%v %+v: call delta - put delta = %.10f", tt.model, tt.in, c.Delta-p.Delta)
+		}
+
+		if math.Abs(c.Gamma-p.Gamma) > 1e-12 || math.Abs(c.Vega-p.Vega) > 1e-12 {
+			t.Errorf("%v %+v: gamma or vega differ between call and put", tt.model, tt.in)
+		}
+	}
+}
+
+func TestAmericanBinomial(t *testing.T) {
+	tests := []OptionInputs{
+		option("P", 100, 100, 1, 0.05, 0, 0.2),
+		option("P", 90, 100, 0.5, 0.08, 0, 0.3),
+		option("C", 100, 100, 1, 0.05, 0, 0.2),
+		option("C", 100, 90, 0.5, 0.03, 0.06, 0.25),
+	}
+
+	for _, in := range tests {
+		american := AmericanBinomial.Greeks(in)
+		european := BlackScholes.Greeks(in)
+
+		if american.Price < european.Price-0.02 {
+			t.Errorf("%+v: american %.4f below european %.4f", in, american.Price, european.Price)
+		}
+
+		if in.Right == "C" && (american.Delta < 0 || american.Delta > 1) || in.Right == "P" && (american.Delta < -1 || american.Delta > 0) {
+			t.Errorf("%+v: american delta %.4f out of range", in, american.Delta)
+		}
+	}
+
+	// without dividends an american call is never exercised early
+	in := option("C", 100, 100, 1, 0.05, 0, 0.2)
+
+	if d := AmericanBinomial.Price(in) - BlackScholes.Price(in); math.Abs(d) > 0.02 {
+		t.Errorf("american call differs from european by %.4f", d)
+	}
+}
+
+func TestImpliedVolConverges(t *testing.T) {
+	models := []struct {
+		model     OptionModel
+		tolerance float64
+	}{
+		{BlackScholes, 1e-6},
+		{Black76, 1e-6},
+		{AmericanBinomial, 1e-4},
+	}
+
+	inputs := []OptionInputs{
+		option("C", 100, 100, 1, 0.05, 0, 0),
+		option("P", 100, 100, 1, 0.05, 0, 0),
+		option("C", 100, 115, 0.25, 0.02, 0.01, 0),
+		option("P", 100, 85, 0.25, 0.02, 0.01, 0),
+		option("C", 100, 95, 2, 0.04, 0, 0),
+	}
+
+	vols := []float64{0.08, 0.2, 0.45, 1.2}
+
+	for _, m := range models {
+		for _, in := range inputs {
+			for _, v := range vols {
+				in.Volatility = v
+				price := m.model.Price(in)
+
+				got, err := m.model.ImpliedVol(in, price)
+
+				if err != nil {
+					t.Errorf("%v %+v: %v", m.model, in, err)
+					continue
+				}
+
+				if math.Abs(got-v) > m.tolerance {
+					t.Errorf("%v %+v: implied vol %.8f, want %.8f", m.model, in, got, v)
+				}
+			}
+		}
+	}
+}
+
+func TestImpliedVolNoSolution(t *testing.T) {
+	in := option("C", 100, 90, 1, 0.05, 0, 0)
+
+	tests := []struct {
+		in    OptionInputs
+		price float64
+	}{
+		{in, 0},
+		{in, 5},   // below the discounted intrinsic value
+		{in, 150}, // above the underlying
+		{option("C", 100, 90, 0, 0.05, 0, 0), 12},
+	}
+
+	for _, tt := range tests {
+		if _, err := BlackScholes.ImpliedVol(tt.in, tt.price); err != ErrNoImpliedVol {
+			t.Errorf("ImpliedVol(%+v, %v) = %v, want ErrNoImpliedVol", tt.in, tt.price, err)
+		}
+	}
+}
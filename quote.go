@@ -0,0 +1,347 @@
+package ib
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// QUOTES
+////////////////////////////////////////////////////////////////////////////////
+
+type Quote struct {
+	Rid           int64
+	Contract      Contract
+	Bid           float64
+	BidSize       int64
+	Ask           float64
+	AskSize       int64
+	Last          float64
+	LastSize      int64
+	High          float64
+	Low           float64
+	Close         float64
+	Open          float64
+	Volume        int64
+	LastTimestamp time.Time
+	Halted        bool
+	Delayed       bool        // delayed tick types were received or delayed data was selected
+	Greeks        TickOptComp // from the model option computation
+	RTVolume      RTVolume
+	Dividends     DividendInfo
+	Fundamentals  FundamentalRatios
+	Updated       time.Time
+}
+
+// QuoteField is a set of Quote fields, used to report what a tick changed.
+type QuoteField uint64
+
+const (
+	QuoteBid QuoteField = 1 << iota
+	QuoteBidSize
+	QuoteAsk
+	QuoteAskSize
+	QuoteLast
+	QuoteLastSize
+	QuoteHigh
+	QuoteLow
+	QuoteClose
+	QuoteOpen
+	QuoteVolume
+	QuoteLastTimestamp
+	QuoteHalted
+	QuoteGreeks
+	QuoteDelayed
+	QuoteRTVolume
+	QuoteDividends
+	QuoteFundamentals
+)
+
+func (f QuoteField) Has(o QuoteField) bool {
+	return f&o != 0
+}
+
+type QuoteUpdate struct {
+	Quote   Quote
+	Changed QuoteField
+}
+
+// QuoteBook keeps the current Quote of every market data request. Handle
+// feeds it from a MarketDataDispatcher. When UpdateChan is not nil every change is sent
+// on it and must be received.
+type QuoteBook struct {
+	UpdateChan chan QuoteUpdate
+	quotes     map[int64]*Quote
+	mu         sync.RWMutex
+}
+
+func NewQuoteBook(notify bool) *QuoteBook {
+	q := &QuoteBook{quotes: make(map[int64]*Quote)}
+
+	if notify {
+		q.UpdateChan = make(chan QuoteUpdate)
+	}
+
+	return q
+}
+
+// Handle is a MarketDataHandler that applies ticks and market data types to
+// the book. Register it on the MarketDataDispatcher of the broker.
+func (q *QuoteBook) Handle(c Contract, t interface{}) {
+	switch r := t.(type) {
+	case MarketDataType:
+		q.SetMode(c, r.Rid, r.Mode())
+	default:
+		q.Apply(c, t)
+	}
+}
+
+// Apply updates the quote of the tick's request with a TickPrice, TickSize,
+// TickOptComp, TickGeneric or TickString and returns the fields it changed.
+func (q *QuoteBook) Apply(c Contract, t interface{}) QuoteField {
+	var rid int64
+
+	switch r := t.(type) {
+	case TickPrice:
+		rid = r.Rid
+	case TickSize:
+		rid = r.Rid
+	case TickOptComp:
+		rid = r.Rid
+	case TickGeneric:
+		rid = r.Rid
+	case TickString:
+		rid = r.Rid
+	default:
+		return 0
+	}
+
+	q.mu.Lock()
+
+	u, ok := q.quotes[rid]
+
+	if !ok {
+		u = &Quote{Rid: rid, Contract: c}
+		q.quotes[rid] = u
+	}
+
+	var f QuoteField
+
+	// delayed ticks update the real-time fields and flag the quote; bid, ask
+	// and last prices carry their size as well
+	switch r := t.(type) {
+	case TickPrice:
+		r.TickType = u.realTime(r.TickType, &f)
+		f |= u.applyPrice(r.TickType, r.Price)
+
+		if s, ok := r.TickType.SizeTickType(); ok {
+			f |= u.applySize(s, r.Size)
+		}
+	case TickSize:
+		r.TickType = u.realTime(r.TickType, &f)
+		f |= u.applySize(r.TickType, r.Size)
+	case TickOptComp:
+		r.TickType = u.realTime(r.TickType, &f)
+		if r.TickType == TickModelOption {
+			u.Greeks = r
+			f |= QuoteGreeks
+		}
+	case TickGeneric:
+		if r.TickType == TickHalted {
+			h := r.Value > 0
+			if h != u.Halted {
+				u.Halted = h
+				f = QuoteHalted
+			}
+		}
+	case TickString:
+		r.TickType = u.realTime(r.TickType, &f)
+		f |= u.applyString(&r)
+	}
+
+	if f != 0 {
+		u.Updated = time.Now()
+	}
+
+	snapshot := *u
+
+	q.mu.Unlock()
+
+	if f != 0 && q.UpdateChan != nil {
+		q.UpdateChan <- QuoteUpdate{snapshot, f}
+	}
+
+	return f
+}
+
+func (u *Quote) realTime(t TickType, f *QuoteField) TickType {
+	t, delayed := t.RealTime()
+
+	if delayed && !u.Delayed {
+		u.Delayed = true
+		*f |= QuoteDelayed
+	}
+
+	return t
+}
+
+// SetMode records the market data mode reported for a request.
+func (q *QuoteBook) SetMode(c Contract, rid int64, m MarketDataMode) {
+	q.mu.Lock()
+
+	u, ok := q.quotes[rid]
+
+	if !ok {
+		u = &Quote{Rid: rid, Contract: c}
+		q.quotes[rid] = u
+	}
+
+	changed := u.Delayed != m.Delayed()
+	u.Delayed = m.Delayed()
+	snapshot := *u
+
+	q.mu.Unlock()
+
+	if changed && q.UpdateChan != nil {
+		q.UpdateChan <- QuoteUpdate{snapshot, QuoteDelayed}
+	}
+}
+
+func (u *Quote) applyString(r *TickString) QuoteField {
+	switch r.TickType {
+	case TickLastTimestamp:
+		if sec, err := strconv.ParseInt(r.Value, 10, 64); err == nil {
+			u.LastTimestamp = time.Unix(sec, 0)
+			return QuoteLastTimestamp
+		}
+	case TickRTVolume, TickRTTradeVolume:
+		v, err := r.RTVolume()
+
+		if err != nil {
+			Log.Print("error", err)
+			return 0
+		}
+
+		u.RTVolume = v
+
+		return QuoteRTVolume
+	case TickIBDividends:
+		v, err := r.DividendInfo()
+
+		if err != nil {
+			Log.Print("error", err)
+			return 0
+		}
+
+		u.Dividends = v
+
+		return QuoteDividends
+	case TickFundamentalRatios:
+		v, err := r.FundamentalRatios()
+
+		if err != nil {
+			Log.Print("error", err)
+			return 0
+		}
+
+		u.Fundamentals = v
+
+		return QuoteFundamentals
+	}
+
+	return 0
+}
+
+func (u *Quote) applyPrice(tickType TickType, p float64) QuoteField {
+	var dst *float64
+	var f QuoteField
+
+	switch tickType {
+	case TickBid:
+		dst, f = &u.Bid, QuoteBid
+	case TickAsk:
+		dst, f = &u.Ask, QuoteAsk
+	case TickLast:
+		dst, f = &u.Last, QuoteLast
+	case TickHigh:
+		dst, f = &u.High, QuoteHigh
+	case TickLow:
+		dst, f = &u.Low, QuoteLow
+	case TickClose:
+		dst, f = &u.Close, QuoteClose
+	case TickOpen:
+		dst, f = &u.Open, QuoteOpen
+	default:
+		return 0
+	}
+
+	if *dst == p {
+		return 0
+	}
+
+	*dst = p
+
+	return f
+}
+
+func (u *Quote) applySize(tickType TickType, s int64) QuoteField {
+	var dst *int64
+	var f QuoteField
+
+	switch tickType {
+	case TickBidSize:
+		dst, f = &u.BidSize, QuoteBidSize
+	case TickAskSize:
+		dst, f = &u.AskSize, QuoteAskSize
+	case TickLastSize:
+		dst, f = &u.LastSize, QuoteLastSize
+	case TickVolume:
+		dst, f = &u.Volume, QuoteVolume
+	default:
+		return 0
+	}
+
+	if *dst == s {
+		return 0
+	}
+
+	*dst = s
+
+	return f
+}
+
+// Quote returns the quote of the request for contract c.
+func (q *QuoteBook) Quote(c Contract) (Quote, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	for _, u := range q.quotes {
+		if u.Contract.Equal(&c) {
+			return *u, true
+		}
+	}
+
+	return Quote{}, false
+}
+
+func (q *QuoteBook) QuoteByRid(rid int64) (Quote, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	u, ok := q.quotes[rid]
+
+	if !ok {
+		return Quote{}, false
+	}
+
+	return *u, true
+}
+
+// Remove forgets the quote of a cancelled request.
+func (q *QuoteBook) Remove(rid int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.quotes, rid)
+}
@@ -0,0 +1,37 @@
+package ib
+
+import "testing"
+
+func TestQuoteBookPriceCarriesSize(t *testing.T) {
+	c := Contract{Symbol: "AAPL", SecurityType: "STK", Exchange: "SMART", Currency: "USD"}
+	q := NewQuoteBook(false)
+
+	tests := []struct {
+		tick     interface{}
+		changed  QuoteField
+		bid      float64
+		bidSize  int64
+		last     float64
+		lastSize int64
+	}{
+		{TickPrice{Rid: 1, TickType: TickBid, Price: 101.5, Size: 300}, QuoteBid | QuoteBidSize, 101.5, 300, 0, 0},
+		{TickPrice{Rid: 1, TickType: TickBid, Price: 101.6, Size: 200}, QuoteBid | QuoteBidSize, 101.6, 200, 0, 0},
+		{TickPrice{Rid: 1, TickType: TickBid, Price: 101.6, Size: 500}, QuoteBidSize, 101.6, 500, 0, 0},
+		{TickSize{Rid: 1, TickType: TickBidSize, Size: 400}, QuoteBidSize, 101.6, 400, 0, 0},
+		{TickPrice{Rid: 1, TickType: TickLast, Price: 101.55, Size: 100}, QuoteLast | QuoteLastSize, 101.6, 400, 101.55, 100},
+		{TickPrice{Rid: 1, TickType: TickDelayedLast, Price: 101.6, Size: 50}, QuoteLast | QuoteLastSize | QuoteDelayed, 101.6, 400, 101.6, 50},
+		{TickPrice{Rid: 1, TickType: TickHigh, Price: 102, Size: 0}, QuoteHigh, 101.6, 400, 101.6, 50},
+	}
+
+	for i, tt := range tests {
+		if got := q.Apply(c, tt.tick); got != tt.changed {
+			t.Errorf("%d: Apply(%+v) changed %b, want %b", i, tt.tick, got, tt.changed)
+		}
+
+		u, _ := q.QuoteByRid(1)
+
+		if u.Bid != tt.bid || u.BidSize != tt.bidSize || u.Last != tt.last || u.LastSize != tt.lastSize {
+			t.Errorf("%d: bid %v x %d, last %v x %d, want %v x %d, %v x %d", i, u.Bid, u.BidSize, u.Last, u.LastSize, tt.bid, tt.bidSize, tt.last, tt.lastSize)
+		}
+	}
+}
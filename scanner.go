@@ -0,0 +1,541 @@
+package ib
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// REQUESTS
+////////////////////////////////////////////////////////////////////////////////
+
+type ScannerParametersRequest struct{}
+
+func init() {
+	REQUEST_CODE["ScannerParameters"] = 24
+	REQUEST_VERSION["ScannerParameters"] = 1
+}
+
+func (r *ScannerParametersRequest) Send(b *ScannerBroker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.WriteInt(REQUEST_CODE["ScannerParameters"])
+	b.WriteInt(REQUEST_VERSION["ScannerParameters"])
+
+	b.Broker.SendRequest()
+}
+
+// ScannerSubscription selects the instruments of a scan. Numeric filters set
+// to MAX_FLOAT or MAX_INT are not applied; NewScannerSubscription returns a
+// subscription with every filter unset.
+type ScannerSubscription struct {
+	NumberOfRows             int64
+	Instrument               string // e.g. "STK"
+	LocationCode             string // e.g. "STK.US.MAJOR"
+	ScanCode                 string // e.g. "TOP_PERC_GAIN"
+	AbovePrice               float64
+	BelowPrice               float64
+	AboveVolume              int64
+	MarketCapAbove           float64
+	MarketCapBelow           float64
+	MoodyRatingAbove         string
+	MoodyRatingBelow         string
+	SpRatingAbove            string
+	SpRatingBelow            string
+	MaturityDateAbove        string
+	MaturityDateBelow        string
+	CouponRateAbove          float64
+	CouponRateBelow          float64
+	ExcludeConvertible       bool
+	AverageOptionVolumeAbove int64
+	ScannerSettingPairs      string
+	StockTypeFilter          string // "ALL", "CORP" or "ADR"
+	Options                  []TagValue
+}
+
+func NewScannerSubscription(instrument, location, scanCode string) ScannerSubscription {
+	return ScannerSubscription{
+		NumberOfRows:             -1,
+		Instrument:               instrument,
+		LocationCode:             location,
+		ScanCode:                 scanCode,
+		AbovePrice:               MAX_FLOAT,
+		BelowPrice:               MAX_FLOAT,
+		AboveVolume:              MAX_INT,
+		MarketCapAbove:           MAX_FLOAT,
+		MarketCapBelow:           MAX_FLOAT,
+		CouponRateAbove:          MAX_FLOAT,
+		CouponRateBelow:          MAX_FLOAT,
+		AverageOptionVolumeAbove: MAX_INT,
+	}
+}
+
+type ScannerSubscriptionRequest struct {
+	Rid          int64
+	Subscription ScannerSubscription
+}
+
+func init() {
+	REQUEST_CODE["ScannerSubscription"] = 22
+	REQUEST_VERSION["ScannerSubscription"] = 4
+}
+
+func (r *ScannerSubscriptionRequest) Send(b *ScannerBroker) {
+	s := &r.Subscription
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Subscriptions[r.Rid] = r.Subscription
+	b.WriteInt(REQUEST_CODE["ScannerSubscription"])
+	b.WriteInt(REQUEST_VERSION["ScannerSubscription"])
+	b.WriteInt(r.Rid)
+
+	if s.NumberOfRows > 0 {
+		b.WriteInt(s.NumberOfRows)
+	} else {
+		b.WriteString("")
+	}
+
+	b.WriteString(s.Instrument)
+	b.WriteString(s.LocationCode)
+	b.WriteString(s.ScanCode)
+	b.writeFilterFloat(s.AbovePrice)
+	b.writeFilterFloat(s.BelowPrice)
+	b.writeFilterInt(s.AboveVolume)
+	b.writeFilterFloat(s.MarketCapAbove)
+	b.writeFilterFloat(s.MarketCapBelow)
+	b.WriteString(s.MoodyRatingAbove)
+	b.WriteString(s.MoodyRatingBelow)
+	b.WriteString(s.SpRatingAbove)
+	b.WriteString(s.SpRatingBelow)
+	b.WriteString(s.MaturityDateAbove)
+	b.WriteString(s.MaturityDateBelow)
+	b.writeFilterFloat(s.CouponRateAbove)
+	b.writeFilterFloat(s.CouponRateBelow)
+	b.WriteBool(s.ExcludeConvertible)
+	b.writeFilterInt(s.AverageOptionVolumeAbove)
+	b.WriteString(s.ScannerSettingPairs)
+	b.WriteString(s.StockTypeFilter)
+
+	var opts string
+
+	for _, o := range s.Options {
+		opts += o.Tag + "=" + o.Value + ";"
+	}
+
+	b.WriteString(opts)
+
+	b.Broker.SendRequest()
+}
+
+// writeFilterFloat writes an empty field for an unset filter.
+func (b *ScannerBroker) writeFilterFloat(f float64) {
+	if f == MAX_FLOAT {
+		b.WriteString("")
+		return
+	}
+
+	b.WriteFloat(f)
+}
+
+func (b *ScannerBroker) writeFilterInt(i int64) {
+	if i == MAX_INT {
+		b.WriteString("")
+		return
+	}
+
+	b.WriteInt(i)
+}
+
+type CancelScannerSubscriptionRequest struct {
+	Rid int64
+}
+
+func init() {
+	REQUEST_CODE["CancelScannerSubscription"] = 23
+	REQUEST_VERSION["CancelScannerSubscription"] = 1
+}
+
+func (r *CancelScannerSubscriptionRequest) Send(b *ScannerBroker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.WriteInt(REQUEST_CODE["CancelScannerSubscription"])
+	b.WriteInt(REQUEST_VERSION["CancelScannerSubscription"])
+	b.WriteInt(r.Rid)
+
+	b.Broker.SendRequest()
+
+	delete(b.Subscriptions, r.Rid)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RESPONSES
+////////////////////////////////////////////////////////////////////////////////
+
+// ScannerData is one refresh of a scan, with its rows ordered by rank.
+type ScannerData struct {
+	Rid  int64
+	Rows []ScannerRow
+}
+
+type ScannerRow struct {
+	Rank            int64
+	ContractDetails ContractDetails
+	Distance        string
+	Benchmark       string
+	Projection      string
+	Legs            string // combo legs, for combination scans
+}
+
+func init() {
+	RESPONSE_CODE["ScannerData"] = "20"
+}
+
+// ScannerParameters describes the scans the gateway offers. XML holds the
+// document as sent; the other fields are decoded from it.
+type ScannerParameters struct {
+	XML           string            `xml:"-"`
+	Instruments   []ScanInstrument  `xml:"InstrumentList>Instrument"`
+	Locations     []ScanLocation    `xml:"LocationTree>Location"`
+	ScanTypes     []ScanType        `xml:"ScanTypeList>ScanType"`
+	RangeFilters  []ScanFilter      `xml:"FilterList>RangeFilter"`
+	SimpleFilters []ScanFilter      `xml:"FilterList>SimpleFilter"`
+	Settings      []ScanFilterField `xml:"SettingList>AbstractField"`
+}
+
+type ScanInstrument struct {
+	Name         string `xml:"name"`
+	Type         string `xml:"type"`
+	SecurityType string `xml:"secType"`
+	Filters      string `xml:"filters"` // comma separated filter ids
+}
+
+type ScanLocation struct {
+	DisplayName  string         `xml:"displayName"`
+	LocationCode string         `xml:"locationCode"`
+	Instruments  string         `xml:"instruments"`
+	Locations    []ScanLocation `xml:"LocationTree>Location"`
+}
+
+type ScanType struct {
+	DisplayName     string `xml:"displayName"`
+	ScanCode        string `xml:"scanCode"`
+	Instruments     string `xml:"instruments"` // comma separated instrument types
+	AbsoluteColumns bool   `xml:"absoluteColumns"`
+	Vendor          string `xml:"vendor"`
+}
+
+type ScanFilter struct {
+	Id       string            `xml:"id"`
+	Category string            `xml:"category"`
+	Access   string            `xml:"access"`
+	Fields   []ScanFilterField `xml:"AbstractField"`
+}
+
+type ScanFilterField struct {
+	Code        string `xml:"code"`
+	DisplayName string `xml:"displayName"`
+	Tooltip     string `xml:"tooltip"`
+	Type        string `xml:"type,attr"`
+}
+
+func init() {
+	RESPONSE_CODE["ScannerParameters"] = "19"
+}
+
+func ParseScannerParameters(s string) (ScannerParameters, error) {
+	var p ScannerParameters
+
+	err := xml.Unmarshal([]byte(s), &p)
+	p.XML = s
+
+	if err != nil {
+		return p, fmt.Errorf("scanner parameters: %v", err)
+	}
+
+	return p, nil
+}
+
+// ScanCodes returns the scans available for an instrument type such as "STK".
+func (p *ScannerParameters) ScanCodes(instrument string) []ScanType {
+	var r []ScanType
+
+	for _, t := range p.ScanTypes {
+		for _, i := range strings.Split(t.Instruments, ",") {
+			if strings.TrimSpace(i) == instrument {
+				r = append(r, t)
+				break
+			}
+		}
+	}
+
+	return r
+}
+
+// Location returns the location with the given code, searching nested
+// locations.
+func (p *ScannerParameters) Location(code string) (ScanLocation, bool) {
+	return findScanLocation(p.Locations, code)
+}
+
+func findScanLocation(l []ScanLocation, code string) (ScanLocation, bool) {
+	for _, loc := range l {
+		if loc.LocationCode == code {
+			return loc, true
+		}
+
+		if r, ok := findScanLocation(loc.Locations, code); ok {
+			return r, true
+		}
+	}
+
+	return ScanLocation{}, false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BROKER
+////////////////////////////////////////////////////////////////////////////////
+
+type ScannerBroker struct {
+	Broker
+	Subscriptions         map[int64]ScannerSubscription
+	ScannerParametersChan chan ScannerParameters
+	ScannerDataChan       chan ScannerData
+	mu                    *sync.Mutex
+}
+
+func NewScannerBroker() ScannerBroker {
+	b := ScannerBroker{
+		Broker{},
+		make(map[int64]ScannerSubscription),
+		make(chan ScannerParameters),
+		make(chan ScannerData),
+		&sync.Mutex{},
+	}
+
+	return b
+}
+
+// Subscription returns the scan running under rid.
+func (b *ScannerBroker) Subscription(rid int64) (ScannerSubscription, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.Subscriptions[rid]
+
+	return s, ok
+}
+
+// Subscribe starts a scan and returns its request id. Every refresh is sent
+// on ScannerDataChan until Cancel is called.
+func (b *ScannerBroker) Subscribe(s ScannerSubscription) int64 {
+	id := b.NextReqId()
+
+	r := ScannerSubscriptionRequest{id, s}
+	r.Send(b)
+
+	return id
+}
+
+func (b *ScannerBroker) Cancel(rid int64) {
+	r := CancelScannerSubscriptionRequest{rid}
+	r.Send(b)
+}
+
+func (b *ScannerBroker) Listen() {
+	for {
+		s, err := b.ReadString()
+
+		if err != nil {
+			continue
+		}
+
+		version, err := b.ReadString()
+
+		if err != nil {
+			continue
+		}
+
+		switch s {
+		case RESPONSE_CODE["ScannerData"]:
+			r, err := b.ReadScannerData(version)
+
+			if err != nil {
+				Log.Print("error", err)
+				continue
+			}
+
+			b.ScannerDataChan <- r
+		case RESPONSE_CODE["ScannerParameters"]:
+			x, _ := b.ReadString()
+			r, err := ParseScannerParameters(x)
+
+			if err != nil {
+				Log.Print("error", err)
+			}
+
+			b.ScannerParametersChan <- r
+		case RESPONSE_CODE["ErrMsg"]:
+			id, code, msg := b.ReadErrMsg(version)
+
+			if IsWarningCode(code) {
+				continue
+			}
+
+			// errors end the scan
+			b.mu.Lock()
+			delete(b.Subscriptions, id)
+			b.mu.Unlock()
+
+			Log.Print("error", fmt.Sprintf("scanner request %d: error %d: %s", id, code, msg))
+		}
+	}
+}
+
+// ReadScannerData decodes a ScannerData message according to its version and
+// orders the rows by rank.
+func (b *ScannerBroker) ReadScannerData(version string) (ScannerData, error) {
+	var r ScannerData
+	var n int64
+
+	ver, err := strconv.ParseInt(version, 10, 64)
+
+	if err != nil {
+		return r, fmt.Errorf("scanner data version %q: %v", version, err)
+	}
+
+	if err := b.ReadFields(&r.Rid, &n); err != nil {
+		return r, fmt.Errorf("scanner data: %v", err)
+	}
+
+	r.Rows = make([]ScannerRow, 0, n)
+
+	var errs []error
+
+	for i := int64(0); i < n; i++ {
+		var row ScannerRow
+		d := &row.ContractDetails
+
+		errs = append(errs, b.ReadFields(&row.Rank))
+
+		if ver >= 3 {
+			errs = append(errs, b.ReadFields(&d.ContractId))
+		}
+
+		errs = append(errs, b.ReadFields(
+			&d.Symbol,
+			&d.SecurityType,
+			&d.Expiry,
+			&d.Strike,
+			&d.Right,
+			&d.Exchange,
+			&d.Currency,
+			&d.LocalSymbol,
+			&d.MarketName,
+			&d.TradingClass,
+			&row.Distance,
+			&row.Benchmark,
+			&row.Projection,
+		))
+
+		if ver >= 2 {
+			errs = append(errs, b.ReadFields(&row.Legs))
+		}
+
+		d.Rid = r.Rid
+		r.Rows = append(r.Rows, row)
+	}
+
+	sort.SliceStable(r.Rows, func(i, j int) bool {
+		return r.Rows[i].Rank < r.Rows[j].Rank
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return r, fmt.Errorf("scanner data %d: %v", r.Rid, err)
+		}
+	}
+
+	return r, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SERIALIZERS
+////////////////////////////////////////////////////////////////////////////////
+
+func (b *ScannerBroker) ScannerRowToJSON(rid int64, d *ScannerRow) ([]byte, error) {
+	c := &d.ContractDetails
+	return json.Marshal(struct {
+		Rid          int64
+		Time         string
+		Rank         int64
+		ContractId   int64
+		Symbol       string
+		SecurityType string
+		Exchange     string
+		Currency     string
+		Right        string
+		Strike       float64
+		Expiry       string
+		LocalSymbol  string
+		MarketName   string
+		TradingClass string
+		Distance     string
+		Benchmark    string
+		Projection   string
+		Legs         string
+	}{
+		Rid:          rid,
+		Time:         strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		Rank:         d.Rank,
+		ContractId:   c.ContractId,
+		Symbol:       c.Symbol,
+		SecurityType: c.SecurityType,
+		Exchange:     c.Exchange,
+		Currency:     c.Currency,
+		Right:        c.Right,
+		Strike:       c.Strike,
+		Expiry:       c.Expiry,
+		LocalSymbol:  c.LocalSymbol,
+		MarketName:   c.MarketName,
+		TradingClass: c.TradingClass,
+		Distance:     d.Distance,
+		Benchmark:    d.Benchmark,
+		Projection:   d.Projection,
+		Legs:         d.Legs,
+	})
+}
+
+func (b *ScannerBroker) ScannerRowToCSV(rid int64, d *ScannerRow) string {
+	c := &d.ContractDetails
+	return fmt.Sprintf(
+		"%d,%s,%d,%d,%s,%s,%s,%s,%s,%.2f,%s,%s,%s,%s,%s,%s,%s,%s",
+		rid,
+		strconv.FormatInt(time.Now().UTC().Add(-5*time.Hour).UnixNano(), 10),
+		d.Rank,
+		c.ContractId,
+		c.Symbol,
+		c.SecurityType,
+		c.Exchange,
+		c.Currency,
+		c.Right,
+		c.Strike,
+		c.Expiry,
+		c.LocalSymbol,
+		c.MarketName,
+		c.TradingClass,
+		d.Distance,
+		d.Benchmark,
+		d.Projection,
+		d.Legs,
+	)
+}
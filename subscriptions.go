@@ -0,0 +1,328 @@
+package ib
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// SUBSCRIPTIONS
+////////////////////////////////////////////////////////////////////////////////
+
+var ErrMaxLines = errors.New("market data line budget exhausted")
+
+// SUBSCRIBER_BUFFER is the capacity of a Subscriber's TickChan.
+var SUBSCRIBER_BUFFER = 256
+
+// Subscriber receives the ticks of one shared market data request on TickChan
+// as TickPrice, TickSize, TickOptComp, TickGeneric, TickString, TickEFP and
+// MarketDataType values. A subscriber joining a request that is already
+// running first receives the last value of every tick type seen so far. A
+// subscriber that stops reading holds up every other subscriber once its
+// buffer is full. When the gateway ends the request with an error, Err is set
+// and TickChan is closed.
+type Subscriber struct {
+	Id       int64
+	Rid      int64 // the shared market data request
+	Contract Contract
+	TickChan chan interface{}
+	Err      error // valid once TickChan is closed
+	done     chan struct{}
+	stop     sync.Once
+}
+
+func (u *Subscriber) close() {
+	u.stop.Do(func() { close(u.done) })
+}
+
+// subscriptionKey is the identity of a market data request: every contract
+// field the request sends, normalized, and the generic tick list.
+type subscriptionKey struct {
+	Contract     Contract
+	GenericTicks string
+}
+
+// lastTickKey identifies a cached tick by its message and tick type.
+type lastTickKey struct {
+	kind     int
+	tickType TickType
+}
+
+type subscription struct {
+	rid         int64
+	key         subscriptionKey
+	subscribers map[int64]*Subscriber
+	last        map[lastTickKey]interface{}
+}
+
+// SubscriptionManager shares market data requests between subscribers. Requests
+// for the same contract and generic tick list use one market data line, which
+// is cancelled when the last subscriber leaves and dropped when the gateway
+// ends it with an error. MaxLines, when positive, caps
+// the number of lines in use.
+type SubscriptionManager struct {
+	Broker   *MarketDataBroker
+	MaxLines int
+	subs     map[subscriptionKey]*subscription
+	byRid    map[int64]*subscription
+	nextId   int64
+	mu       sync.Mutex
+}
+
+func NewSubscriptionManager(b *MarketDataBroker, maxLines int) *SubscriptionManager {
+	return &SubscriptionManager{
+		Broker:   b,
+		MaxLines: maxLines,
+		subs:     make(map[subscriptionKey]*subscription),
+		byRid:    make(map[int64]*subscription),
+	}
+}
+
+// normalizeGenericTicks sorts and dedupes a generic tick list so that "233,100"
+// and "100,233" share a line.
+func normalizeGenericTicks(s string) string {
+	seen := make(map[string]bool)
+	var l []string
+
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+
+		if t != "" && !seen[t] {
+			seen[t] = true
+			l = append(l, t)
+		}
+	}
+
+	sort.Strings(l)
+
+	return strings.Join(l, ",")
+}
+
+func newSubscriptionKey(c *Contract, genericTicks string) subscriptionKey {
+	norm := func(s string) string { return strings.ToUpper(strings.TrimSpace(s)) }
+
+	return subscriptionKey{
+		Contract: Contract{
+			ContractId:      c.ContractId,
+			Symbol:          norm(c.Symbol),
+			SecurityType:    norm(c.SecurityType),
+			Expiry:          strings.TrimSpace(c.Expiry),
+			Strike:          c.Strike,
+			Right:           normalizeRight(c.Right),
+			Multiplier:      strings.TrimSpace(c.Multiplier),
+			Exchange:        norm(c.Exchange),
+			PrimaryExchange: norm(c.PrimaryExchange),
+			Currency:        norm(c.Currency),
+			LocalSymbol:     strings.TrimSpace(c.LocalSymbol),
+			TradingClass:    strings.TrimSpace(c.TradingClass),
+		},
+		GenericTicks: genericTicks,
+	}
+}
+
+// Subscribe adds a subscriber for c and the generic ticks. A market data
+// request is sent only when no other subscriber shares it; ErrMaxLines is
+// returned when that request would exceed MaxLines.
+func (m *SubscriptionManager) Subscribe(c Contract, genericTicks string) (*Subscriber, error) {
+	ticks := normalizeGenericTicks(genericTicks)
+	key := newSubscriptionKey(&c, ticks)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subs[key]
+
+	if !ok {
+		if m.MaxLines > 0 && len(m.subs) >= m.MaxLines {
+			return nil, ErrMaxLines
+		}
+
+		s = &subscription{
+			rid:         m.Broker.NextReqId(),
+			key:         key,
+			subscribers: make(map[int64]*Subscriber),
+			last:        make(map[lastTickKey]interface{}),
+		}
+
+		m.subs[key] = s
+		m.byRid[s.rid] = s
+
+		r := MarketDataRequest{s.rid, c, ticks, false}
+		r.Send(m.Broker)
+	}
+
+	m.nextId++
+
+	u := &Subscriber{
+		Id:       m.nextId,
+		Rid:      s.rid,
+		Contract: c,
+		TickChan: make(chan interface{}, SUBSCRIBER_BUFFER),
+		done:     make(chan struct{}),
+	}
+
+	s.subscribers[u.Id] = u
+	s.replay(u)
+
+	return u, nil
+}
+
+// Unsubscribe removes u and cancels its market data request when no
+// subscriber is left. Nothing is sent on u.TickChan afterwards.
+func (m *SubscriptionManager) Unsubscribe(u *Subscriber) {
+	// unblock a Handle waiting on u before taking the lock it fans out under
+	u.close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.byRid[u.Rid]
+
+	if !ok {
+		return
+	}
+
+	if _, ok := s.subscribers[u.Id]; !ok {
+		return
+	}
+
+	delete(s.subscribers, u.Id)
+
+	if len(s.subscribers) > 0 {
+		return
+	}
+
+	delete(m.subs, s.key)
+	delete(m.byRid, s.rid)
+
+	r := CancelMarketDataRequest{s.rid}
+	r.Send(m.Broker)
+}
+
+// Lines returns the number of market data lines in use.
+func (m *SubscriptionManager) Lines() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.subs)
+}
+
+// Subscribers returns the number of subscribers sharing the request of rid.
+func (m *SubscriptionManager) Subscribers(rid int64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.byRid[rid]; ok {
+		return len(s.subscribers)
+	}
+
+	return 0
+}
+
+// Handle is a MarketDataHandler that hands every tick to the subscribers of
+// its request. An error ends the line: it is handed to every subscriber as Err
+// and their channels are closed. Register it on the MarketDataDispatcher of
+// the broker.
+func (m *SubscriptionManager) Handle(c Contract, t interface{}) {
+	if e, ok := t.(MarketDataError); ok {
+		m.fail(e)
+		return
+	}
+
+	k, rid, ok := lastTick(t)
+
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.byRid[rid]
+
+	if !ok {
+		return
+	}
+
+	s.last[k] = t
+
+	for _, u := range s.subscribers {
+		select {
+		case u.TickChan <- t:
+		case <-u.done:
+		}
+	}
+}
+
+// fail removes the line that e ended and closes its subscribers.
+func (m *SubscriptionManager) fail(e MarketDataError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.byRid[e.Rid]
+
+	if !ok {
+		return
+	}
+
+	delete(m.subs, s.key)
+	delete(m.byRid, s.rid)
+	m.Broker.forgetContract(s.rid)
+
+	for _, u := range s.subscribers {
+		u.Err = e
+		u.close()
+		close(u.TickChan)
+	}
+}
+
+// lastTick returns the cache key and request id of a tick. Snapshot ends are
+// not passed on.
+func lastTick(t interface{}) (lastTickKey, int64, bool) {
+	switch r := t.(type) {
+	case TickPrice:
+		return lastTickKey{0, r.TickType}, r.Rid, true
+	case TickSize:
+		return lastTickKey{1, r.TickType}, r.Rid, true
+	case TickOptComp:
+		return lastTickKey{2, r.TickType}, r.Rid, true
+	case TickGeneric:
+		return lastTickKey{3, r.TickType}, r.Rid, true
+	case TickString:
+		return lastTickKey{4, r.TickType}, r.Rid, true
+	case TickEFP:
+		return lastTickKey{5, r.TickType}, r.Rid, true
+	case MarketDataType:
+		return lastTickKey{6, 0}, r.Rid, true
+	default:
+		return lastTickKey{}, 0, false
+	}
+}
+
+// replay sends the cached ticks of s to a new subscriber, market data type
+// first. TickChan is empty at this point; ticks beyond its capacity are
+// dropped.
+func (s *subscription) replay(u *Subscriber) {
+	keys := make([]lastTickKey, 0, len(s.last))
+
+	for k := range s.last {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind > keys[j].kind
+		}
+		return keys[i].tickType < keys[j].tickType
+	})
+
+	for _, k := range keys {
+		select {
+		case u.TickChan <- s.last[k]:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package ib
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// PRICE INCREMENTS
+////////////////////////////////////////////////////////////////////////////////
+
+type RoundingMode int64
+
+const (
+	RoundNearest    RoundingMode = iota
+	RoundPassive                 // away from the market: down for buys, up for sells
+	RoundAggressive              // through the market: up for buys, down for sells
+	RoundUp
+	RoundDown
+)
+
+// RoundToTick rounds price to a multiple of tick. action ("BUY" or "SELL")
+// decides the direction for RoundPassive and RoundAggressive.
+func RoundToTick(price, tick float64, action string, mode RoundingMode) float64 {
+	if tick <= 0 || price == MAX_FLOAT || math.IsNaN(price) || math.IsInf(price, 0) {
+		return price
+	}
+
+	buy := strings.ToUpper(action) != "SELL" && strings.ToUpper(action) != "SSHORT"
+
+	switch mode {
+	case RoundPassive:
+		if buy {
+			mode = RoundDown
+		} else {
+			mode = RoundUp
+		}
+	case RoundAggressive:
+		if buy {
+			mode = RoundUp
+		} else {
+			mode = RoundDown
+		}
+	}
+
+	// tolerate float noise so that 1.1/0.01 is treated as exactly 110 ticks
+	n := price / tick
+	const eps = 1e-9
+
+	switch mode {
+	case RoundUp:
+		n = math.Ceil(n - eps)
+	case RoundDown:
+		n = math.Floor(n + eps)
+	default:
+		n = math.Round(n)
+	}
+
+	return roundDecimals(n*tick, tickDecimals(tick))
+}
+
+// tickDecimals returns the number of decimals needed to write tick.
+func tickDecimals(tick float64) int {
+	s := strconv.FormatFloat(tick, 'f', -1, 64)
+
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+
+	return 0
+}
+
+func roundDecimals(v float64, n int) float64 {
+	r, err := strconv.ParseFloat(strconv.FormatFloat(v, 'f', n, 64), 64)
+
+	if err != nil {
+		return v
+	}
+
+	return r
+}
+
+// RoundPrice rounds price to the minimum tick of the contract.
+func (d *ContractDetails) RoundPrice(price float64, action string, mode RoundingMode) float64 {
+	return RoundToTick(price, d.MinTick, action, mode)
+}
+
+// ScalePrice converts a price reported in market or historical data to the
+// units used for orders by dividing by the price magnifier.
+func (d *ContractDetails) ScalePrice(price float64) float64 {
+	if d.PriceMagnifier <= 1 {
+		return price
+	}
+
+	return price / float64(d.PriceMagnifier)
+}
+
+// RoundOrderPrices rounds the prices of o to the minimum tick of the
+// contract according to its order type. The limit price and the trigger of
+// touched orders (MIT, LIT) follow mode as given. Stop triggers (the aux price
+// of STP and STP LMT, and TrailStopPrice) sit on the other side of the market,
+// so passive rounding moves them up for buys and down for sells. Trailing
+// amounts and pegged offsets are distances rather than prices: passive rounding
+// widens them and aggressive rounding narrows them. Unset prices (MAX_FLOAT)
+// are left alone.
+func RoundOrderPrices(o *Order, d *ContractDetails, mode RoundingMode) {
+	o.LimitPrice = d.RoundPrice(o.LimitPrice, o.Action, mode)
+	o.TrailStopPrice = d.RoundPrice(o.TrailStopPrice, oppositeAction(o.Action), mode)
+
+	switch strings.ToUpper(strings.TrimSpace(o.OrderType)) {
+	case "STP", "STP LMT", "STP PRT":
+		o.AuxPrice = d.RoundPrice(o.AuxPrice, oppositeAction(o.Action), mode)
+	case "MIT", "LIT":
+		o.AuxPrice = d.RoundPrice(o.AuxPrice, o.Action, mode)
+	case "TRAIL", "TRAIL LIMIT", "TRAIL LIT", "TRAIL MIT", "REL", "PEG MKT", "PEG MID", "PEG PRIM", "PEG STK", "PEG BENCH":
+		o.AuxPrice = roundOffset(o.AuxPrice, d.MinTick, mode)
+	default:
+		o.AuxPrice = d.RoundPrice(o.AuxPrice, o.Action, RoundNearest)
+	}
+}
+
+func oppositeAction(action string) string {
+	switch strings.ToUpper(action) {
+	case "SELL", "SSHORT":
+		return "BUY"
+	default:
+		return "SELL"
+	}
+}
+
+// roundOffset rounds a non-negative distance to a multiple of tick.
+func roundOffset(offset, tick float64, mode RoundingMode) float64 {
+	switch mode {
+	case RoundPassive:
+		mode = RoundUp
+	case RoundAggressive:
+		mode = RoundDown
+	}
+
+	return RoundToTick(offset, tick, "BUY", mode)
+}
+
+var (
+	ErrNoContractId = errors.New("tick rules are keyed by contract id; the contract has none")
+	ErrNoTickRule   = errors.New("no tick rule for the contract")
+)
+
+// TickRules holds the contract details, keyed by contract id, that brokers use
+// to round order prices and to scale prices by the price magnifier. It is safe
+// for concurrent use, so rules can be added while a broker is listening.
+type TickRules struct {
+	rules map[int64]ContractDetails
+	mu    sync.RWMutex
+}
+
+func NewTickRules() *TickRules {
+	return &TickRules{rules: make(map[int64]ContractDetails)}
+}
+
+func (t *TickRules) Add(d ContractDetails) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rules[d.ContractId] = d
+}
+
+func (t *TickRules) Remove(contractId int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.rules, contractId)
+}
+
+func (t *TickRules) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.rules)
+}
+
+// Get returns the rule for c. Rules are looked up by contract id only, since
+// a spec without an id can match several listings with different ticks; a
+// contract without an id returns ErrNoContractId, and a contract without a
+// rule ErrNoTickRule.
+func (t *TickRules) Get(c *Contract) (ContractDetails, error) {
+	if c.ContractId == 0 {
+		return ContractDetails{}, ErrNoContractId
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	d, ok := t.rules[c.ContractId]
+
+	if !ok {
+		return ContractDetails{}, ErrNoTickRule
+	}
+
+	return d, nil
+}
@@ -0,0 +1,187 @@
+package ib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// STRING TICKS
+////////////////////////////////////////////////////////////////////////////////
+
+// RTVolume is a decoded RT_VOLUME (48) or RT_TRD_VOLUME (77) tick. Price and
+// Size are zero for updates that only carry the total volume and VWAP.
+type RTVolume struct {
+	Rid         int64
+	Price       float64
+	Size        int64
+	Time        time.Time
+	TotalVolume int64
+	VWAP        float64
+	SingleTrade bool
+}
+
+// DividendInfo is a decoded IB_DIVIDENDS (59) tick.
+type DividendInfo struct {
+	Rid          int64
+	Past12Months float64
+	Next12Months float64
+	NextDate     time.Time
+	NextAmount   float64
+}
+
+// FundamentalRatios is a decoded FUNDAMENTAL_RATIOS (47) tick. Ratios holds
+// the numeric values and Raw every value as sent.
+type FundamentalRatios struct {
+	Rid    int64
+	Ratios map[string]float64
+	Raw    map[string]string
+}
+
+func parseTickFloat(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseTickInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+
+	// some gateways send sizes with a decimal point
+	f, err := strconv.ParseFloat(s, 64)
+
+	return int64(f), err
+}
+
+// ParseRTVolume decodes "price;size;time;totalVolume;VWAP;singleTrade", where
+// time is in epoch milliseconds.
+func ParseRTVolume(s string) (RTVolume, error) {
+	var r RTVolume
+
+	f := strings.Split(s, ";")
+
+	if len(f) < 5 {
+		return r, fmt.Errorf("invalid RT volume %q", s)
+	}
+
+	var err error
+	var ms int64
+
+	if r.Price, err = parseTickFloat(f[0]); err != nil {
+		return r, fmt.Errorf("invalid RT volume %q: %v", s, err)
+	}
+
+	if r.Size, err = parseTickInt(f[1]); err != nil {
+		return r, fmt.Errorf("invalid RT volume %q: %v", s, err)
+	}
+
+	if ms, err = parseTickInt(f[2]); err != nil {
+		return r, fmt.Errorf("invalid RT volume %q: %v", s, err)
+	}
+
+	if r.TotalVolume, err = parseTickInt(f[3]); err != nil {
+		return r, fmt.Errorf("invalid RT volume %q: %v", s, err)
+	}
+
+	if r.VWAP, err = parseTickFloat(f[4]); err != nil {
+		return r, fmt.Errorf("invalid RT volume %q: %v", s, err)
+	}
+
+	if len(f) > 5 {
+		r.SingleTrade = strings.EqualFold(f[5], "true")
+	}
+
+	r.Time = time.Unix(0, ms*int64(time.Millisecond))
+
+	return r, nil
+}
+
+// ParseDividendInfo decodes "past12,next12,nextDate,nextAmount", where
+// nextDate is YYYYMMDD.
+func ParseDividendInfo(s string) (DividendInfo, error) {
+	var r DividendInfo
+
+	f := strings.Split(s, ",")
+
+	if len(f) < 4 {
+		return r, fmt.Errorf("invalid dividends %q", s)
+	}
+
+	var err error
+
+	if r.Past12Months, err = parseTickFloat(f[0]); err != nil {
+		return r, fmt.Errorf("invalid dividends %q: %v", s, err)
+	}
+
+	if r.Next12Months, err = parseTickFloat(f[1]); err != nil {
+		return r, fmt.Errorf("invalid dividends %q: %v", s, err)
+	}
+
+	if f[2] != "" {
+		if r.NextDate, err = time.Parse("20060102", f[2]); err != nil {
+			return r, fmt.Errorf("invalid dividends %q: %v", s, err)
+		}
+	}
+
+	if r.NextAmount, err = parseTickFloat(f[3]); err != nil {
+		return r, fmt.Errorf("invalid dividends %q: %v", s, err)
+	}
+
+	return r, nil
+}
+
+// ParseFundamentalRatios decodes "KEY=VAL;KEY=VAL;...".
+func ParseFundamentalRatios(s string) (FundamentalRatios, error) {
+	r := FundamentalRatios{
+		Ratios: make(map[string]float64),
+		Raw:    make(map[string]string),
+	}
+
+	for _, kv := range strings.Split(s, ";") {
+		if kv == "" {
+			continue
+		}
+
+		i := strings.Index(kv, "=")
+
+		if i < 0 {
+			return r, fmt.Errorf("invalid fundamental ratio %q", kv)
+		}
+
+		k, v := kv[:i], kv[i+1:]
+		r.Raw[k] = v
+
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			r.Ratios[k] = f
+		}
+	}
+
+	return r, nil
+}
+
+func (t *TickString) RTVolume() (RTVolume, error) {
+	r, err := ParseRTVolume(t.Value)
+	r.Rid = t.Rid
+	return r, err
+}
+
+func (t *TickString) DividendInfo() (DividendInfo, error) {
+	r, err := ParseDividendInfo(t.Value)
+	r.Rid = t.Rid
+	return r, err
+}
+
+func (t *TickString) FundamentalRatios() (FundamentalRatios, error) {
+	r, err := ParseFundamentalRatios(t.Value)
+	r.Rid = t.Rid
+	return r, err
+}
@@ -0,0 +1,149 @@
+package ib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRTVolume(t *testing.T) {
+	tests := []struct {
+		s    string
+		want RTVolume
+	}{
+		{
+			"701.28;1;1348075471534;67854;701.46918464;true",
+			RTVolume{Price: 701.28, Size: 1, Time: time.Unix(1348075471, 534e6), TotalVolume: 67854, VWAP: 701.46918464, SingleTrade: true},
+		},
+		{
+			"701.28;100;1348075471534;67954;701.46918464;false",
+			RTVolume{Price: 701.28, Size: 100, Time: time.Unix(1348075471, 534e6), TotalVolume: 67954, VWAP: 701.46918464},
+		},
+		{
+			";;1348075471534;67954;701.46918464;false",
+			RTVolume{Time: time.Unix(1348075471, 534e6), TotalVolume: 67954, VWAP: 701.46918464},
+		},
+		{
+			"1.5;2.0;1348075471534;10;1.5",
+			RTVolume{Price: 1.5, Size: 2, Time: time.Unix(1348075471, 534e6), TotalVolume: 10, VWAP: 1.5},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRTVolume(tt.s)
+
+		if err != nil {
+			t.Errorf("ParseRTVolume(%q): %v", tt.s, err)
+			continue
+		}
+
+		if !got.Time.Equal(tt.want.Time) {
+			t.Errorf("ParseRTVolume(%q): time %v, want %v", tt.s, got.Time, tt.want.Time)
+		}
+
+		got.Time, tt.want.Time = time.Time{}, time.Time{}
+
+		if got != tt.want {
+			t.Errorf("ParseRTVolume(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "701.28;1;1348075471534;67854", "x;1;1348075471534;67854;701.4", "701.28;1;t;67854;701.4"} {
+		if _, err := ParseRTVolume(s); err == nil {
+			t.Errorf("ParseRTVolume(%q): expected an error", s)
+		}
+	}
+}
+
+func TestParseDividendInfo(t *testing.T) {
+	tests := []struct {
+		s    string
+		want DividendInfo
+	}{
+		{"0.83,0.92,20130219,0.23", DividendInfo{Past12Months: 0.83, Next12Months: 0.92, NextDate: time.Date(2013, 2, 19, 0, 0, 0, 0, time.UTC), NextAmount: 0.23}},
+		{",,,", DividendInfo{}},
+		{"1.2,,,", DividendInfo{Past12Months: 1.2}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDividendInfo(tt.s)
+
+		if err != nil {
+			t.Errorf("ParseDividendInfo(%q): %v", tt.s, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseDividendInfo(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "0.83,0.92,20130219", "0.83,0.92,2013-02-19,0.23", "x,0.92,20130219,0.23"} {
+		if _, err := ParseDividendInfo(s); err == nil {
+			t.Errorf("ParseDividendInfo(%q): expected an error", s)
+		}
+	}
+}
+
+func TestParseFundamentalRatios(t *testing.T) {
+	tests := []struct {
+		s      string
+		ratios map[string]float64
+		raw    map[string]string
+	}{
+		{
+			"TTMNPMGN=16.1298;NLOW=80.6;TTMPRCFPS=6.26675;CURRENCY=USD;",
+			map[string]float64{"TTMNPMGN": 16.1298, "NLOW": 80.6, "TTMPRCFPS": 6.26675},
+			map[string]string{"TTMNPMGN": "16.1298", "NLOW": "80.6", "TTMPRCFPS": "6.26675", "CURRENCY": "USD"},
+		},
+		{
+			"",
+			map[string]float64{},
+			map[string]string{},
+		},
+		{
+			"EPS=-99999.99;NAME=",
+			map[string]float64{"EPS": -99999.99},
+			map[string]string{"EPS": "-99999.99", "NAME": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFundamentalRatios(tt.s)
+
+		if err != nil {
+			t.Errorf("ParseFundamentalRatios(%q): %v", tt.s, err)
+			continue
+		}
+
+		if len(got.Ratios) != len(tt.ratios) || len(got.Raw) != len(tt.raw) {
+			t.Errorf("ParseFundamentalRatios(%q) = %v, %v, want %v, %v", tt.s, got.Ratios, got.Raw, tt.ratios, tt.raw)
+			continue
+		}
+
+		for k, v := range tt.ratios {
+			if got.Ratios[k] != v {
+				t.Errorf("ParseFundamentalRatios(%q): %s = %v, want %v", tt.s, k, got.Ratios[k], v)
+			}
+		}
+
+		for k, v := range tt.raw {
+			if r, ok := got.Raw[k]; !ok || r != v {
+				t.Errorf("ParseFundamentalRatios(%q): raw %s = %q, want %q", tt.s, k, r, v)
+			}
+		}
+	}
+
+	if _, err := ParseFundamentalRatios("TTMNPMGN=16.1;NLOW"); err == nil {
+		t.Error("expected an error for a ratio without a value")
+	}
+}
+
+func TestTickStringRid(t *testing.T) {
+	s := TickString{Rid: 7, TickType: TickRTVolume, Value: "1.5;2;1348075471534;10;1.5;true"}
+
+	v, err := s.RTVolume()
+
+	if err != nil || v.Rid != 7 {
+		t.Errorf("RTVolume() = %+v, %v", v, err)
+	}
+}
@@ -0,0 +1,440 @@
+package ib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// TICK TYPES
+////////////////////////////////////////////////////////////////////////////////
+
+type TickType int64
+
+const (
+	TickBidSize TickType = iota
+	TickBid
+	TickAsk
+	TickAskSize
+	TickLast
+	TickLastSize
+	TickHigh
+	TickLow
+	TickVolume
+	TickClose
+	TickBidOption
+	TickAskOption
+	TickLastOption
+	TickModelOption
+	TickOpen
+	TickLow13Week
+	TickHigh13Week
+	TickLow26Week
+	TickHigh26Week
+	TickLow52Week
+	TickHigh52Week
+	TickAverageVolume
+	TickOpenInterest
+	TickOptionHistoricalVol
+	TickOptionImpliedVol
+	TickOptionBidExch
+	TickOptionAskExch
+	TickOptionCallOpenInterest
+	TickOptionPutOpenInterest
+	TickOptionCallVolume
+	TickOptionPutVolume
+	TickIndexFuturePremium
+	TickBidExch
+	TickAskExch
+	TickAuctionVolume
+	TickAuctionPrice
+	TickAuctionImbalance
+	TickMarkPrice
+	TickBidEFP
+	TickAskEFP
+	TickLastEFP
+	TickOpenEFP
+	TickHighEFP
+	TickLowEFP
+	TickCloseEFP
+	TickLastTimestamp
+	TickShortable
+	TickFundamentalRatios
+	TickRTVolume
+	TickHalted
+	TickBidYield
+	TickAskYield
+	TickLastYield
+	TickCustOptionComputation
+	TickTradeCount
+	TickTradeRate
+	TickVolumeRate
+	TickLastRTHTrade
+	TickRTHistoricalVol
+	TickIBDividends
+	TickBondFactorMultiplier
+	TickRegulatoryImbalance
+	TickNews
+	TickShortTermVolume3Min
+	TickShortTermVolume5Min
+	TickShortTermVolume10Min
+	TickDelayedBid
+	TickDelayedAsk
+	TickDelayedLast
+	TickDelayedBidSize
+	TickDelayedAskSize
+	TickDelayedLastSize
+	TickDelayedHigh
+	TickDelayedLow
+	TickDelayedVolume
+	TickDelayedClose
+	TickDelayedOpen
+	TickRTTradeVolume
+	TickCreditmanMarkPrice
+	TickCreditmanSlowMarkPrice
+	TickDelayedBidOption
+	TickDelayedAskOption
+	TickDelayedLastOption
+	TickDelayedModelOption
+	TickLastExch
+	TickLastRegTime
+	TickFuturesOpenInterest
+	TickAverageOptVolume
+	TickDelayedLastTimestamp
+	TickShortableShares
+)
+
+var TICK_TYPE_NAME = map[TickType]string{
+	TickBidSize:                "BID_SIZE",
+	TickBid:                    "BID",
+	TickAsk:                    "ASK",
+	TickAskSize:                "ASK_SIZE",
+	TickLast:                   "LAST",
+	TickLastSize:               "LAST_SIZE",
+	TickHigh:                   "HIGH",
+	TickLow:                    "LOW",
+	TickVolume:                 "VOLUME",
+	TickClose:                  "CLOSE",
+	TickBidOption:              "BID_OPTION_COMPUTATION",
+	TickAskOption:              "ASK_OPTION_COMPUTATION",
+	TickLastOption:             "LAST_OPTION_COMPUTATION",
+	TickModelOption:            "MODEL_OPTION_COMPUTATION",
+	TickOpen:                   "OPEN",
+	TickLow13Week:              "LOW_13_WEEK",
+	TickHigh13Week:             "HIGH_13_WEEK",
+	TickLow26Week:              "LOW_26_WEEK",
+	TickHigh26Week:             "HIGH_26_WEEK",
+	TickLow52Week:              "LOW_52_WEEK",
+	TickHigh52Week:             "HIGH_52_WEEK",
+	TickAverageVolume:          "AVG_VOLUME",
+	TickOpenInterest:           "OPEN_INTEREST",
+	TickOptionHistoricalVol:    "OPTION_HISTORICAL_VOL",
+	TickOptionImpliedVol:       "OPTION_IMPLIED_VOL",
+	TickOptionBidExch:          "OPTION_BID_EXCH",
+	TickOptionAskExch:          "OPTION_ASK_EXCH",
+	TickOptionCallOpenInterest: "OPTION_CALL_OPEN_INTEREST",
+	TickOptionPutOpenInterest:  "OPTION_PUT_OPEN_INTEREST",
+	TickOptionCallVolume:       "OPTION_CALL_VOLUME",
+	TickOptionPutVolume:        "OPTION_PUT_VOLUME",
+	TickIndexFuturePremium:     "INDEX_FUTURE_PREMIUM",
+	TickBidExch:                "BID_EXCH",
+	TickAskExch:                "ASK_EXCH",
+	TickAuctionVolume:          "AUCTION_VOLUME",
+	TickAuctionPrice:           "AUCTION_PRICE",
+	TickAuctionImbalance:       "AUCTION_IMBALANCE",
+	TickMarkPrice:              "MARK_PRICE",
+	TickBidEFP:                 "BID_EFP_COMPUTATION",
+	TickAskEFP:                 "ASK_EFP_COMPUTATION",
+	TickLastEFP:                "LAST_EFP_COMPUTATION",
+	TickOpenEFP:                "OPEN_EFP_COMPUTATION",
+	TickHighEFP:                "HIGH_EFP_COMPUTATION",
+	TickLowEFP:                 "LOW_EFP_COMPUTATION",
+	TickCloseEFP:               "CLOSE_EFP_COMPUTATION",
+	TickLastTimestamp:          "LAST_TIMESTAMP",
+	TickShortable:              "SHORTABLE",
+	TickFundamentalRatios:      "FUNDAMENTAL_RATIOS",
+	TickRTVolume:               "RT_VOLUME",
+	TickHalted:                 "HALTED",
+	TickBidYield:               "BID_YIELD",
+	TickAskYield:               "ASK_YIELD",
+	TickLastYield:              "LAST_YIELD",
+	TickCustOptionComputation:  "CUST_OPTION_COMPUTATION",
+	TickTradeCount:             "TRADE_COUNT",
+	TickTradeRate:              "TRADE_RATE",
+	TickVolumeRate:             "VOLUME_RATE",
+	TickLastRTHTrade:           "LAST_RTH_TRADE",
+	TickRTHistoricalVol:        "RT_HISTORICAL_VOL",
+	TickIBDividends:            "IB_DIVIDENDS",
+	TickBondFactorMultiplier:   "BOND_FACTOR_MULTIPLIER",
+	TickRegulatoryImbalance:    "REGULATORY_IMBALANCE",
+	TickNews:                   "NEWS_TICK",
+	TickShortTermVolume3Min:    "SHORT_TERM_VOLUME_3_MIN",
+	TickShortTermVolume5Min:    "SHORT_TERM_VOLUME_5_MIN",
+	TickShortTermVolume10Min:   "SHORT_TERM_VOLUME_10_MIN",
+	TickDelayedBid:             "DELAYED_BID",
+	TickDelayedAsk:             "DELAYED_ASK",
+	TickDelayedLast:            "DELAYED_LAST",
+	TickDelayedBidSize:         "DELAYED_BID_SIZE",
+	TickDelayedAskSize:         "DELAYED_ASK_SIZE",
+	TickDelayedLastSize:        "DELAYED_LAST_SIZE",
+	TickDelayedHigh:            "DELAYED_HIGH",
+	TickDelayedLow:             "DELAYED_LOW",
+	TickDelayedVolume:          "DELAYED_VOLUME",
+	TickDelayedClose:           "DELAYED_CLOSE",
+	TickDelayedOpen:            "DELAYED_OPEN",
+	TickRTTradeVolume:          "RT_TRD_VOLUME",
+	TickCreditmanMarkPrice:     "CREDITMAN_MARK_PRICE",
+	TickCreditmanSlowMarkPrice: "CREDITMAN_SLOW_MARK_PRICE",
+	TickDelayedBidOption:       "DELAYED_BID_OPTION_COMPUTATION",
+	TickDelayedAskOption:       "DELAYED_ASK_OPTION_COMPUTATION",
+	TickDelayedLastOption:      "DELAYED_LAST_OPTION_COMPUTATION",
+	TickDelayedModelOption:     "DELAYED_MODEL_OPTION_COMPUTATION",
+	TickLastExch:               "LAST_EXCH",
+	TickLastRegTime:            "LAST_REG_TIME",
+	TickFuturesOpenInterest:    "FUTURES_OPEN_INTEREST",
+	TickAverageOptVolume:       "AVG_OPT_VOLUME",
+	TickDelayedLastTimestamp:   "DELAYED_LAST_TIMESTAMP",
+	TickShortableShares:        "SHORTABLE_SHARES",
+}
+
+func (t TickType) String() string {
+	if s, ok := TICK_TYPE_NAME[t]; ok {
+		return s
+	}
+
+	return strconv.FormatInt(int64(t), 10)
+}
+
+// RealTime maps a delayed tick type onto its real-time equivalent and reports
+// whether t was delayed.
+func (t TickType) RealTime() (TickType, bool) {
+	switch {
+	case t >= TickDelayedBid && t <= TickDelayedOpen:
+		return DELAYED_TICK_TYPE[t], true
+	case t >= TickDelayedBidOption && t <= TickDelayedModelOption:
+		return t - TickDelayedBidOption + TickBidOption, true
+	case t == TickDelayedLastTimestamp:
+		return TickLastTimestamp, true
+	}
+
+	return t, false
+}
+
+var DELAYED_TICK_TYPE = map[TickType]TickType{
+	TickDelayedBid:      TickBid,
+	TickDelayedAsk:      TickAsk,
+	TickDelayedLast:     TickLast,
+	TickDelayedBidSize:  TickBidSize,
+	TickDelayedAskSize:  TickAskSize,
+	TickDelayedLastSize: TickLastSize,
+	TickDelayedHigh:     TickHigh,
+	TickDelayedLow:      TickLow,
+	TickDelayedVolume:   TickVolume,
+	TickDelayedClose:    TickClose,
+	TickDelayedOpen:     TickOpen,
+}
+
+// PRICE_SIZE_TICK_TYPE maps the price tick types that carry a size onto the
+// size tick type the size belongs to.
+var PRICE_SIZE_TICK_TYPE = map[TickType]TickType{
+	TickBid:         TickBidSize,
+	TickAsk:         TickAskSize,
+	TickLast:        TickLastSize,
+	TickDelayedBid:  TickDelayedBidSize,
+	TickDelayedAsk:  TickDelayedAskSize,
+	TickDelayedLast: TickDelayedLastSize,
+}
+
+// SizeTickType returns the size tick type of the size sent with a price tick
+// of type t, if it has one.
+func (t TickType) SizeTickType() (TickType, bool) {
+	s, ok := PRICE_SIZE_TICK_TYPE[t]
+	return s, ok
+}
+
+// LEGACY_TICK_TYPE_NAME holds the names the serializers have always written
+// for the first tick types. Other tick types are written as numbers, and
+// TickType values marshal to JSON as numbers, so existing consumers of the
+// JSON and CSV output keep working.
+var LEGACY_TICK_TYPE_NAME = map[TickType]string{
+	TickBidSize:  "BID SIZE",
+	TickBid:      "BID",
+	TickAsk:      "ASK",
+	TickAskSize:  "ASK_SIZE",
+	TickLast:     "LAST",
+	TickLastSize: "LAST SIZE",
+	TickHigh:     "HIGH",
+	TickLow:      "LOW",
+	TickVolume:   "VOLUME",
+	TickClose:    "CLOSE",
+}
+
+// LegacyString returns the name written by the serializers.
+func (t TickType) LegacyString() string {
+	if s, ok := LEGACY_TICK_TYPE_NAME[t]; ok {
+		return s
+	}
+
+	return strconv.FormatInt(int64(t), 10)
+}
+
+// UnmarshalJSON accepts a tick type number, name or legacy name.
+func (t *TickType) UnmarshalJSON(buf []byte) error {
+	var s string
+
+	if err := json.Unmarshal(buf, &s); err != nil {
+		var n int64
+
+		if err := json.Unmarshal(buf, &n); err != nil {
+			return fmt.Errorf("invalid tick type %s", buf)
+		}
+
+		*t = TickType(n)
+
+		return nil
+	}
+
+	for k, v := range LEGACY_TICK_TYPE_NAME {
+		if v == s {
+			*t = k
+			return nil
+		}
+	}
+
+	v, err := ParseTickType(s)
+
+	if err != nil {
+		return err
+	}
+
+	*t = v
+
+	return nil
+}
+
+func ParseTickType(s string) (TickType, error) {
+	for k, v := range TICK_TYPE_NAME {
+		if strings.EqualFold(v, s) {
+			return k, nil
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid tick type %q", s)
+	}
+
+	return TickType(n), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GENERIC TICKS
+////////////////////////////////////////////////////////////////////////////////
+
+// GenericTick is an id requested in MarketDataRequest.GenericTickList.
+type GenericTick int64
+
+const (
+	GenericOptionVolume           GenericTick = 100
+	GenericOptionOpenInterest     GenericTick = 101
+	GenericHistoricalVolatility   GenericTick = 104
+	GenericAverageOptionVolume    GenericTick = 105
+	GenericImpliedVolatility      GenericTick = 106
+	GenericIndexFuturePremium     GenericTick = 162
+	GenericMiscStats              GenericTick = 165
+	GenericMarkPrice              GenericTick = 221
+	GenericAuctionValues          GenericTick = 225
+	GenericRTVolume               GenericTick = 233
+	GenericShortable              GenericTick = 236
+	GenericInventory              GenericTick = 256
+	GenericFundamentals           GenericTick = 258
+	GenericNews                   GenericTick = 292
+	GenericTradeCount             GenericTick = 293
+	GenericTradeRate              GenericTick = 294
+	GenericVolumeRate             GenericTick = 295
+	GenericLastRTHTrade           GenericTick = 318
+	GenericRTTradeVolume          GenericTick = 375
+	GenericRTHistoricalVolatility GenericTick = 411
+	GenericIBDividends            GenericTick = 456
+	GenericFuturesOpenInterest    GenericTick = 588
+	GenericShortTermVolume        GenericTick = 595
+)
+
+// GENERIC_TICK_SECURITY_TYPES lists the security types each generic tick is
+// valid for. A tick missing from the map is valid for every type.
+var GENERIC_TICK_SECURITY_TYPES = map[GenericTick][]string{
+	GenericOptionVolume:           {"STK", "IND"},
+	GenericOptionOpenInterest:     {"STK", "IND"},
+	GenericHistoricalVolatility:   {"STK", "IND", "OPT"},
+	GenericAverageOptionVolume:    {"STK", "IND"},
+	GenericImpliedVolatility:      {"STK", "IND", "OPT"},
+	GenericIndexFuturePremium:     {"IND"},
+	GenericAuctionValues:          {"STK"},
+	GenericShortable:              {"STK"},
+	GenericInventory:              {"STK"},
+	GenericFundamentals:           {"STK"},
+	GenericRTHistoricalVolatility: {"STK", "IND", "OPT"},
+	GenericIBDividends:            {"STK"},
+	GenericFuturesOpenInterest:    {"FUT"},
+}
+
+// GenericTicks builds a generic tick list, e.g.
+//
+//	GenericTicks{GenericRTVolume, GenericShortable, GenericFundamentals}.String()
+type GenericTicks []GenericTick
+
+func (g GenericTicks) String() string {
+	f := make([]string, len(g))
+
+	for i := range g {
+		f[i] = strconv.FormatInt(int64(g[i]), 10)
+	}
+
+	return strings.Join(f, ",")
+}
+
+// Validate reports a tick that is repeated or not valid for secType.
+func (g GenericTicks) Validate(secType string) error {
+	seen := make(map[GenericTick]bool)
+
+	for _, t := range g {
+		if seen[t] {
+			return fmt.Errorf("generic tick %d requested twice", t)
+		}
+
+		seen[t] = true
+
+		types, ok := GENERIC_TICK_SECURITY_TYPES[t]
+
+		if !ok {
+			continue
+		}
+
+		valid := false
+
+		for _, s := range types {
+			if strings.EqualFold(s, secType) {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			return fmt.Errorf("generic tick %d is not available for %s", t, secType)
+		}
+	}
+
+	return nil
+}
+
+// For validates the list for secType and returns it as a GenericTickList.
+func (g GenericTicks) For(secType string) (string, error) {
+	if err := g.Validate(secType); err != nil {
+		return "", err
+	}
+
+	return g.String(), nil
+}
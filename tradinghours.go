@@ -0,0 +1,258 @@
+package ib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IB reports some time zones by abbreviation. These are mapped onto the IANA
+// locations that observe daylight saving time the way the exchanges do.
+var TIME_ZONE_ID = map[string]string{
+	"EST":     "America/New_York",
+	"EST5EDT": "America/New_York",
+	"EDT":     "America/New_York",
+	"CST":     "America/Chicago",
+	"CST6CDT": "America/Chicago",
+	"CDT":     "America/Chicago",
+	"MST":     "America/Denver",
+	"MST7MDT": "America/Denver",
+	"PST":     "America/Los_Angeles",
+	"PST8PDT": "America/Los_Angeles",
+	"MET":     "Europe/Berlin",
+	"CET":     "Europe/Berlin",
+	"BST":     "Europe/London",
+	"JST":     "Asia/Tokyo",
+	"HKT":     "Asia/Hong_Kong",
+	"AET":     "Australia/Sydney",
+	"AEST":    "Australia/Sydney",
+}
+
+func LoadTimeZone(id string) (*time.Location, error) {
+	id = strings.TrimSpace(id)
+
+	if id == "" {
+		return time.UTC, nil
+	}
+
+	if name, ok := TIME_ZONE_ID[strings.ToUpper(id)]; ok {
+		id = name
+	}
+
+	return time.LoadLocation(id)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SESSIONS
+////////////////////////////////////////////////////////////////////////////////
+
+type Session struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (s Session) Contains(t time.Time) bool {
+	return !t.Before(s.Start) && t.Before(s.End)
+}
+
+type SessionCalendar struct {
+	Location *time.Location
+	Sessions []Session // sorted by start, non-overlapping
+}
+
+// ParseSessions parses a TradingHours or LiquidHours string. Days are
+// separated by ";" and the segments of a day by ",". Both the short form
+// "20160328:0930-1600" and the long form "20160328:0930-20160328:1600" are
+// accepted. A short segment that ends at or before it starts is an overnight
+// session that opens on the previous calendar day, which is how IB labels
+// sessions by the day they close. Closed days have no sessions.
+func ParseSessions(hours string, loc *time.Location) ([]Session, error) {
+	var r []Session
+
+	for _, day := range strings.Split(hours, ";") {
+		day = strings.TrimSpace(day)
+
+		if day == "" {
+			continue
+		}
+
+		i := strings.Index(day, ":")
+
+		if i < 0 {
+			return nil, fmt.Errorf("invalid trading hours %q", day)
+		}
+
+		date, err := time.ParseInLocation("20060102", day[:i], loc)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid trading hours %q: %v", day, err)
+		}
+
+		if strings.EqualFold(day[i+1:], "CLOSED") {
+			continue
+		}
+
+		for _, seg := range strings.Split(day[i+1:], ",") {
+			s, err := parseSegment(date, seg, loc)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid trading hours %q: %v", day, err)
+			}
+
+			r = append(r, s)
+		}
+	}
+
+	return mergeSessions(r), nil
+}
+
+func parseSegment(date time.Time, seg string, loc *time.Location) (Session, error) {
+	var s Session
+
+	p := strings.Split(strings.TrimSpace(seg), "-")
+
+	if len(p) != 2 {
+		return s, fmt.Errorf("segment %q", seg)
+	}
+
+	start, err := parseSegmentTime(date, p[0], loc)
+
+	if err != nil {
+		return s, err
+	}
+
+	end, err := parseSegmentTime(date, p[1], loc)
+
+	if err != nil {
+		return s, err
+	}
+
+	if !end.After(start) {
+		if strings.Contains(p[0], ":") || strings.Contains(p[1], ":") {
+			return s, fmt.Errorf("segment %q ends before it starts", seg)
+		}
+		start = start.AddDate(0, 0, -1)
+	}
+
+	s.Start = start
+	s.End = end
+
+	return s, nil
+}
+
+func parseSegmentTime(date time.Time, v string, loc *time.Location) (time.Time, error) {
+	if i := strings.Index(v, ":"); i >= 0 {
+		return time.ParseInLocation("20060102:1504", v, loc)
+	}
+
+	hm, err := time.Parse("1504", v)
+
+	if err != nil {
+		return hm, err
+	}
+
+	y, m, d := date.Date()
+
+	return time.Date(y, m, d, hm.Hour(), hm.Minute(), 0, 0, loc), nil
+}
+
+func mergeSessions(s []Session) []Session {
+	sort.Slice(s, func(i, j int) bool { return s[i].Start.Before(s[j].Start) })
+
+	var r []Session
+
+	for _, v := range s {
+		if n := len(r); n > 0 && !v.Start.After(r[n-1].End) {
+			if v.End.After(r[n-1].End) {
+				r[n-1].End = v.End
+			}
+			continue
+		}
+		r = append(r, v)
+	}
+
+	return r
+}
+
+func NewSessionCalendar(hours, timeZoneId string) (*SessionCalendar, error) {
+	loc, err := LoadTimeZone(timeZoneId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := ParseSessions(hours, loc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionCalendar{loc, s}, nil
+}
+
+func (d *ContractDetails) TradingSessions() (*SessionCalendar, error) {
+	return NewSessionCalendar(d.TradingHours, d.TimeZoneId)
+}
+
+func (d *ContractDetails) LiquidSessions() (*SessionCalendar, error) {
+	return NewSessionCalendar(d.LiquidHours, d.TimeZoneId)
+}
+
+// index returns the position of the first session that ends after t.
+func (c *SessionCalendar) index(t time.Time) int {
+	return sort.Search(len(c.Sessions), func(i int) bool {
+		return c.Sessions[i].End.After(t)
+	})
+}
+
+func (c *SessionCalendar) IsOpen(t time.Time) bool {
+	i := c.index(t)
+	return i < len(c.Sessions) && c.Sessions[i].Contains(t)
+}
+
+// Session returns the session in progress at t.
+func (c *SessionCalendar) Session(t time.Time) (Session, bool) {
+	i := c.index(t)
+
+	if i < len(c.Sessions) && c.Sessions[i].Contains(t) {
+		return c.Sessions[i], true
+	}
+
+	return Session{}, false
+}
+
+// NextOpen returns the start of the first session beginning after t. The
+// second result is false when the calendar has no later session.
+func (c *SessionCalendar) NextOpen(t time.Time) (time.Time, bool) {
+	for i := c.index(t); i < len(c.Sessions); i++ {
+		if c.Sessions[i].Start.After(t) {
+			return c.Sessions[i].Start.In(c.Location), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// NextClose returns the end of the session in progress at t or, when closed,
+// the end of the next session.
+func (c *SessionCalendar) NextClose(t time.Time) (time.Time, bool) {
+	i := c.index(t)
+
+	if i < len(c.Sessions) {
+		return c.Sessions[i].End.In(c.Location), true
+	}
+
+	return time.Time{}, false
+}
+
+// SessionsBetween returns the sessions that overlap [a, b).
+func (c *SessionCalendar) SessionsBetween(a, b time.Time) []Session {
+	var r []Session
+
+	for i := c.index(a); i < len(c.Sessions) && c.Sessions[i].Start.Before(b); i++ {
+		r = append(r, c.Sessions[i])
+	}
+
+	return r
+}
@@ -0,0 +1,226 @@
+package ib
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, id string) *time.Location {
+	loc, err := LoadTimeZone(id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loc
+}
+
+func TestParseSessions(t *testing.T) {
+	ny := mustLoad(t, "EST5EDT")
+	chi := mustLoad(t, "CST")
+	tok := mustLoad(t, "JST")
+
+	tests := []struct {
+		name  string
+		hours string
+		loc   *time.Location
+		want  []Session
+	}{
+		{
+			name:  "short form",
+			hours: "20160328:0930-1600;20160329:0930-1600",
+			loc:   ny,
+			want: []Session{
+				{time.Date(2016, 3, 28, 9, 30, 0, 0, ny), time.Date(2016, 3, 28, 16, 0, 0, 0, ny)},
+				{time.Date(2016, 3, 29, 9, 30, 0, 0, ny), time.Date(2016, 3, 29, 16, 0, 0, 0, ny)},
+			},
+		},
+		{
+			name:  "closed day",
+			hours: "20160326:CLOSED;20160328:0930-1600",
+			loc:   ny,
+			want: []Session{
+				{time.Date(2016, 3, 28, 9, 30, 0, 0, ny), time.Date(2016, 3, 28, 16, 0, 0, 0, ny)},
+			},
+		},
+		{
+			name:  "overnight short form opens the day before",
+			hours: "20160329:1700-1600",
+			loc:   chi,
+			want: []Session{
+				{time.Date(2016, 3, 28, 17, 0, 0, 0, chi), time.Date(2016, 3, 29, 16, 0, 0, 0, chi)},
+			},
+		},
+		{
+			name:  "long form across midnight",
+			hours: "20160328:1700-20160329:1600",
+			loc:   chi,
+			want: []Session{
+				{time.Date(2016, 3, 28, 17, 0, 0, 0, chi), time.Date(2016, 3, 29, 16, 0, 0, 0, chi)},
+			},
+		},
+		{
+			name:  "segments of a day",
+			hours: "20160328:0900-1130,1230-1500",
+			loc:   tok,
+			want: []Session{
+				{time.Date(2016, 3, 28, 9, 0, 0, 0, tok), time.Date(2016, 3, 28, 11, 30, 0, 0, tok)},
+				{time.Date(2016, 3, 28, 12, 30, 0, 0, tok), time.Date(2016, 3, 28, 15, 0, 0, 0, tok)},
+			},
+		},
+		{
+			name:  "adjacent sessions are merged",
+			hours: "20160328:1700-20160329:0000;20160329:0000-1600",
+			loc:   chi,
+			want: []Session{
+				{time.Date(2016, 3, 28, 17, 0, 0, 0, chi), time.Date(2016, 3, 29, 16, 0, 0, 0, chi)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSessions(tt.hours, tt.loc)
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d sessions, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+
+		for i := range got {
+			if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+				t.Errorf("%s: session %d is %v - %v, want %v - %v", tt.name, i, got[i].Start, got[i].End, tt.want[i].Start, tt.want[i].End)
+			}
+		}
+	}
+}
+
+func TestParseSessionsInvalid(t *testing.T) {
+	for _, hours := range []string{
+		"20160328",
+		"2016032:0930-1600",
+		"20160328:0930",
+		"20160328:0930-16xx",
+		"20160329:1700-20160328:1600",
+	} {
+		if _, err := ParseSessions(hours, time.UTC); err == nil {
+			t.Errorf("%q: expected an error", hours)
+		}
+	}
+}
+
+func TestSessionCalendarBoundaries(t *testing.T) {
+	// a futures week in Chicago: Sunday evening to Friday afternoon with a
+	// daily maintenance break, labelled by the day each session closes
+	c, err := NewSessionCalendar("20160327:CLOSED;20160328:1700-1600;20160329:1700-1600", "CST")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chi := c.Location
+	ny := mustLoad(t, "America/New_York")
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		open      bool
+		nextOpen  time.Time
+		nextClose time.Time
+	}{
+		{
+			name:      "before the first open",
+			at:        time.Date(2016, 3, 27, 16, 59, 0, 0, chi),
+			open:      false,
+			nextOpen:  time.Date(2016, 3, 27, 17, 0, 0, 0, chi),
+			nextClose: time.Date(2016, 3, 28, 16, 0, 0, 0, chi),
+		},
+		{
+			name:      "at the open",
+			at:        time.Date(2016, 3, 27, 17, 0, 0, 0, chi),
+			open:      true,
+			nextOpen:  time.Date(2016, 3, 28, 17, 0, 0, 0, chi),
+			nextClose: time.Date(2016, 3, 28, 16, 0, 0, 0, chi),
+		},
+		{
+			name:      "midnight inside an overnight session",
+			at:        time.Date(2016, 3, 28, 0, 0, 0, 0, chi),
+			open:      true,
+			nextOpen:  time.Date(2016, 3, 28, 17, 0, 0, 0, chi),
+			nextClose: time.Date(2016, 3, 28, 16, 0, 0, 0, chi),
+		},
+		{
+			name:      "at the close",
+			at:        time.Date(2016, 3, 28, 16, 0, 0, 0, chi),
+			open:      false,
+			nextOpen:  time.Date(2016, 3, 28, 17, 0, 0, 0, chi),
+			nextClose: time.Date(2016, 3, 29, 16, 0, 0, 0, chi),
+		},
+		{
+			name:      "during the break, seen from New York",
+			at:        time.Date(2016, 3, 28, 17, 30, 0, 0, ny),
+			open:      false,
+			nextOpen:  time.Date(2016, 3, 28, 17, 0, 0, 0, chi),
+			nextClose: time.Date(2016, 3, 29, 16, 0, 0, 0, chi),
+		},
+		{
+			name:      "after midnight in UTC, before midnight in Chicago",
+			at:        time.Date(2016, 3, 29, 2, 0, 0, 0, time.UTC),
+			open:      true,
+			nextOpen:  time.Time{},
+			nextClose: time.Date(2016, 3, 29, 16, 0, 0, 0, chi),
+		},
+	}
+
+	for _, tt := range tests {
+		if open := c.IsOpen(tt.at); open != tt.open {
+			t.Errorf("%s: IsOpen = %v, want %v", tt.name, open, tt.open)
+		}
+
+		next, ok := c.NextOpen(tt.at)
+
+		if ok != !tt.nextOpen.IsZero() || (ok && !next.Equal(tt.nextOpen)) {
+			t.Errorf("%s: NextOpen = %v, %v, want %v", tt.name, next, ok, tt.nextOpen)
+		}
+
+		if ok && next.Location() != chi {
+			t.Errorf("%s: NextOpen in %v, want %v", tt.name, next.Location(), chi)
+		}
+
+		end, ok := c.NextClose(tt.at)
+
+		if !ok || !end.Equal(tt.nextClose) {
+			t.Errorf("%s: NextClose = %v, %v, want %v", tt.name, end, ok, tt.nextClose)
+		}
+	}
+}
+
+func TestSessionsBetween(t *testing.T) {
+	c, err := NewSessionCalendar("20160328:0930-1600;20160329:0930-1600;20160330:0930-1600", "EST")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ny := c.Location
+
+	tests := []struct {
+		a, b time.Time
+		want int
+	}{
+		{time.Date(2016, 3, 28, 0, 0, 0, 0, ny), time.Date(2016, 3, 31, 0, 0, 0, 0, ny), 3},
+		{time.Date(2016, 3, 28, 12, 0, 0, 0, ny), time.Date(2016, 3, 29, 9, 30, 0, 0, ny), 1},
+		{time.Date(2016, 3, 28, 16, 0, 0, 0, ny), time.Date(2016, 3, 29, 9, 31, 0, 0, ny), 1},
+		{time.Date(2016, 3, 30, 16, 0, 0, 0, ny), time.Date(2016, 4, 1, 0, 0, 0, 0, ny), 0},
+	}
+
+	for _, tt := range tests {
+		if got := len(c.SessionsBetween(tt.a, tt.b)); got != tt.want {
+			t.Errorf("SessionsBetween(%v, %v) returned %d sessions, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}